@@ -0,0 +1,128 @@
+// +build !appengine
+
+// Ficlint checks fictex documents for common mistakes in fiction.
+//
+// Usage:
+//
+//	ficlint [flags] [file ...]
+//
+// Each finding is printed as "file:line:column: message (rule)".  With no
+// files, the standard input is checked.  The exit status is 1 if anything
+// was found.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"fictex"
+	"fictex/lint"
+)
+
+var (
+	disable  = flag.String("disable", "", "comma-separated `rules` not to run")
+	enable   = flag.String("enable", "", "comma-separated `rules` to run instead of all of them")
+	openings = flag.Int("openings", lint.DefaultConfig.Openings, "sentences in a row which may begin with the same word")
+	adverbs  = flag.Float64("adverbs", lint.DefaultConfig.AdverbRatio, "fraction of words which may be adverbs")
+	filter   = flag.String("filter", "", "comma-separated filter `words` to use instead of the defaults")
+	rules    = flag.Bool("rules", false, "list the rules and exit")
+)
+
+func main() {
+	flag.Parse()
+
+	if *rules {
+		for _, r := range lint.Rules {
+			fmt.Printf("%-22s %s\n", r.Name, r.Doc)
+		}
+		return
+	}
+
+	cfg := lint.DefaultConfig
+	cfg.Openings, cfg.AdverbRatio = *openings, *adverbs
+	cfg.Disabled = map[string]bool{}
+	if *enable != "" {
+		for _, r := range lint.Rules {
+			cfg.Disabled[r.Name] = true
+		}
+		for _, name := range split(*enable) {
+			cfg.Disabled[name] = false
+		}
+	}
+	for _, name := range split(*disable) {
+		cfg.Disabled[name] = true
+	}
+	if *filter != "" {
+		cfg.FilterWords = split(*filter)
+	}
+
+	found := false
+	if flag.NArg() == 0 {
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fatal(err)
+		}
+		found = check(&cfg, "<stdin>", src)
+	}
+	for _, name := range flag.Args() {
+		src, err := ioutil.ReadFile(name)
+		if err != nil {
+			fatal(err)
+		}
+		if check(&cfg, name, src) {
+			found = true
+		}
+	}
+	if found {
+		os.Exit(1)
+	}
+}
+
+// check prints the findings in a document and returns whether there were
+// any.
+func check(cfg *lint.Config, name string, src []byte) bool {
+	n, _, err := fictex.ParseDocumentBytes(src)
+	if err != nil {
+		fatal(fmt.Errorf("%s: %s", name, err))
+	}
+	findings := cfg.Lint(n, src)
+	for _, f := range findings {
+		line, col := position(src, f.Pos)
+		fmt.Printf("%s:%d:%d: %s (%s)\n", name, line, col, f.Message, f.Rule)
+	}
+	return len(findings) > 0
+}
+
+// position returns the line and column, counted in characters, of a byte
+// offset in the source.
+func position(src []byte, pos int) (line, col int) {
+	if pos > len(src) {
+		pos = len(src)
+	}
+	before := src[:pos]
+	line = bytes.Count(before, []byte("\n")) + 1
+	if i := bytes.LastIndex(before, []byte("\n")); i >= 0 {
+		before = before[i+1:]
+	}
+	return line, utf8.RuneCount(before) + 1
+}
+
+func split(list string) []string {
+	var out []string
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "ficlint:", err)
+	os.Exit(2)
+}
@@ -0,0 +1,587 @@
+package fictex
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// DiffOp describes how a piece of a document changed between two versions.
+type DiffOp int
+
+const (
+	Equal   DiffOp = iota // Identical in both versions
+	Insert                // Only present in the new version
+	Delete                // Only present in the old version
+	Change                // A block in both versions whose Words differ
+	Restyle               // The same word with different formatting
+)
+
+var opString = [...]string{
+	"Equal", "Insert", "Delete", "Change", "Restyle",
+}
+
+func (op DiffOp) String() string {
+	return opString[op]
+}
+
+// A Word is a single whitespace-separated word of text and its formatting.
+// Dashes and hard line breaks are words of their own with no Text.
+type Word struct {
+	Type  nodeType // Text, an inline style, NDash, MDash, or LineBreak
+	Text  string
+	Space bool // Whether the word was preceded by whitespace
+}
+
+// A WordDiff is a single word within a changed block.
+type WordDiff struct {
+	Op DiffOp
+	Word
+	Was nodeType // The old formatting of a Restyle
+}
+
+// A BlockDiff describes how a single block (paragraph, separator, or preview
+// text) changed.  For an Insert, Old is the zero Node; for a Delete, New is.
+type BlockDiff struct {
+	Op       DiffOp
+	Old, New Node
+	Words    []WordDiff // Only filled in for a Change
+
+	// The quotes, author's notes, and verse the block is in, outermost
+	// first and without their children.  Blocks are only Equal or Changed
+	// if they are in the same kinds of container.
+	In []Node
+}
+
+// A Diff is the list of block-level differences between two documents.
+type Diff []BlockDiff
+
+// DiffBytes parses two fictex sources and compares them.
+func DiffBytes(old, new []byte) (Diff, error) {
+	o, err := ParseBytes(old)
+	if err != nil {
+		return nil, err
+	}
+	n, err := ParseBytes(new)
+	if err != nil {
+		return nil, err
+	}
+	return DiffNodes(o, n), nil
+}
+
+// DiffNodes compares two parsed documents.  Blocks are first matched up
+// as a whole; unmatched paragraphs which share at least half of their
+// words are then compared word-by-word.  The body of a preview is compared
+// as if it were at the top level.
+func DiffNodes(old, new Node) Diff {
+	ob, nb := blocks(old, nil), blocks(new, nil)
+	ow, nw := make([][]Word, len(ob)), make([][]Word, len(nb))
+	for i, b := range ob {
+		ow[i] = words(b.Node)
+	}
+	for j, b := range nb {
+		nw[j] = words(b.Node)
+	}
+
+	match := lcs(len(ob), len(nb), func(i, j int) bool {
+		o, n := ob[i], nb[j]
+		return o.Type == n.Type && o.Align == n.Align && o.Level == n.Level &&
+			string(o.Text) == string(n.Text) && sameWords(ow[i], nw[j]) && sameContainers(o.in, n.in)
+	})
+
+	var d Diff
+	i, j := 0, 0
+	for _, m := range append(match, [2]int{len(ob), len(nb)}) {
+		// Pair up the unmatched blocks in order
+		for k := 0; i+k < m[0] || j+k < m[1]; k++ {
+			oi, nj := i+k, j+k
+			switch {
+			case oi >= m[0]:
+				d = append(d, BlockDiff{Op: Insert, New: nb[nj].Node, In: nb[nj].in})
+			case nj >= m[1]:
+				d = append(d, BlockDiff{Op: Delete, Old: ob[oi].Node, In: ob[oi].in})
+			case similar(ob[oi], nb[nj], ow[oi], nw[nj]):
+				d = append(d, BlockDiff{
+					Op:    Change,
+					Old:   ob[oi].Node,
+					New:   nb[nj].Node,
+					Words: diffWords(ow[oi], nw[nj]),
+					In:    nb[nj].in,
+				})
+			default:
+				d = append(d,
+					BlockDiff{Op: Delete, Old: ob[oi].Node, In: ob[oi].in},
+					BlockDiff{Op: Insert, New: nb[nj].Node, In: nb[nj].in})
+			}
+		}
+		if m[0] < len(ob) {
+			d = append(d, BlockDiff{Op: Equal, Old: ob[m[0]].Node, New: nb[m[1]].Node, In: nb[m[1]].in})
+		}
+		i, j = m[0]+1, m[1]+1
+	}
+	return d
+}
+
+// A leaf is a block-level node and the containers it is in.
+type leaf struct {
+	Node
+	in []Node
+}
+
+// blocks flattens a document into its block-level nodes.  A preview
+// contributes a childless Preview node for its text followed by its body,
+// author's notes and quotes contribute their paragraphs, and verse
+// contributes its lines.  The notes, quotes, and verse are kept, without
+// their children, as the containers of their blocks.
+func blocks(n Node, in []Node) []leaf {
+	switch n.Type {
+	case Group, Preview:
+	case Note, Quote, Verse:
+		in = append(in[:len(in):len(in)], Node{Type: n.Type, Text: n.Text, Pos: n.Pos})
+	default:
+		return []leaf{{n, in}}
+	}
+
+	var out []leaf
+	if n.Type == Preview {
+		out = append(out, leaf{Node{Type: Preview, Text: n.Text}, in})
+	}
+	for _, c := range n.Child {
+		out = append(out, blocks(c, in)...)
+	}
+	return out
+}
+
+// sameContainers reports whether two lists of containers are of the same
+// kinds.
+func sameContainers(a, b []Node) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Type != b[i].Type {
+			return false
+		}
+	}
+	return true
+}
+
+// words splits the inline content of a block into Words.
+func words(n Node) []Word {
+	var out []Word
+	space := false
+
+	add := func(typ nodeType, text []byte) {
+		start := -1
+		for i, c := range text {
+			switch c {
+			case ' ', '\t', '\n':
+				if start >= 0 {
+					out = append(out, Word{typ, string(text[start:i]), space})
+					start, space = -1, false
+				}
+				space = true
+			default:
+				if start < 0 {
+					start = i
+				}
+			}
+		}
+		if start >= 0 {
+			out = append(out, Word{typ, string(text[start:]), space})
+			space = false
+		}
+	}
+
+	if n.Type == Preview {
+		add(Text, n.Text)
+		return out
+	}
+	for _, c := range n.Child {
+		switch c.Type {
+		case NDash, MDash, LineBreak:
+			out = append(out, Word{Type: c.Type, Space: space})
+			space = false
+		default:
+			add(c.Type, c.Text)
+		}
+	}
+	return out
+}
+
+func sameWords(a, b []Word) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Type != b[i].Type || a[i].Text != b[i].Text {
+			return false
+		}
+	}
+	return true
+}
+
+// similar returns true if two blocks are close enough to be shown as a
+// word-by-word change instead of a deletion and an insertion.
+func similar(a, b leaf, aw, bw []Word) bool {
+	if a.Type != b.Type || (a.Type != Paragraph && a.Type != Preview) || !sameContainers(a.in, b.in) {
+		return false
+	}
+	common := len(lcs(len(aw), len(bw), func(i, j int) bool {
+		return aw[i].Text == bw[j].Text
+	}))
+	longest := len(aw)
+	if len(bw) > longest {
+		longest = len(bw)
+	}
+	return 2*common >= longest
+}
+
+// diffWords compares two lists of words.  A run of deleted words followed
+// by a run of inserted words is examined for words whose text is the same
+// but whose formatting changed, which are reported as a Restyle.
+func diffWords(old, new []Word) []WordDiff {
+	match := lcs(len(old), len(new), func(i, j int) bool {
+		return old[i].Type == new[j].Type && old[i].Text == new[j].Text
+	})
+
+	var out []WordDiff
+	i, j := 0, 0
+	for _, m := range append(match, [2]int{len(old), len(new)}) {
+		out = append(out, restyle(old[i:m[0]], new[j:m[1]])...)
+		if m[0] < len(old) {
+			out = append(out, WordDiff{Op: Equal, Word: new[m[1]]})
+		}
+		i, j = m[0]+1, m[1]+1
+	}
+	return out
+}
+
+func restyle(old, new []Word) []WordDiff {
+	match := lcs(len(old), len(new), func(i, j int) bool {
+		return old[i].Text == new[j].Text
+	})
+
+	var out []WordDiff
+	i, j := 0, 0
+	for _, m := range append(match, [2]int{len(old), len(new)}) {
+		for ; i < m[0]; i++ {
+			out = append(out, WordDiff{Op: Delete, Word: old[i]})
+		}
+		for ; j < m[1]; j++ {
+			out = append(out, WordDiff{Op: Insert, Word: new[j]})
+		}
+		if m[0] < len(old) {
+			out = append(out, WordDiff{Op: Restyle, Word: new[m[1]], Was: old[m[0]].Type})
+		}
+		i, j = m[0]+1, m[1]+1
+	}
+	return out
+}
+
+// lcs returns the index pairs of a longest common subsequence of two
+// sequences of lengths n and m whose elements are compared with eq.  The
+// common prefix and suffix are matched first; what remains is compared
+// with Hirschberg's algorithm, which needs space linear in m.
+func lcs(n, m int, eq func(i, j int) bool) [][2]int {
+	var pairs [][2]int
+	var split func(i0, i1, j0, j1 int)
+	split = func(i0, i1, j0, j1 int) {
+		for i0 < i1 && j0 < j1 && eq(i0, j0) {
+			pairs = append(pairs, [2]int{i0, j0})
+			i0, j0 = i0+1, j0+1
+		}
+		suffix := 0
+		for i0 < i1-suffix && j0 < j1-suffix && eq(i1-suffix-1, j1-suffix-1) {
+			suffix++
+		}
+		i1, j1 = i1-suffix, j1-suffix
+
+		switch {
+		case i0 == i1 || j0 == j1:
+		case i1-i0 == 1:
+			for j := j0; j < j1; j++ {
+				if eq(i0, j) {
+					pairs = append(pairs, [2]int{i0, j})
+					break
+				}
+			}
+		default:
+			// Split the second sequence where the halves of the first
+			// have the longest common subsequences between them
+			mid := (i0 + i1) / 2
+			fwd, back := lcsLengths(i0, mid, j0, j1, eq), lcsLengthsBack(mid, i1, j0, j1, eq)
+			best, k := -1, 0
+			for j := range fwd {
+				if l := fwd[j] + back[j]; l > best {
+					best, k = l, j
+				}
+			}
+			split(i0, mid, j0, j0+k)
+			split(mid, i1, j0+k, j1)
+		}
+
+		for k := 0; k < suffix; k++ {
+			pairs = append(pairs, [2]int{i1 + k, j1 + k})
+		}
+	}
+	split(0, n, 0, m)
+	return pairs
+}
+
+// lcsLengths returns the length of the LCS of [i0,i1) and [j0,j0+k) for
+// each k from 0 to j1-j0.
+func lcsLengths(i0, i1, j0, j1 int, eq func(i, j int) bool) []int {
+	prev, cur := make([]int, j1-j0+1), make([]int, j1-j0+1)
+	for i := i0; i < i1; i++ {
+		for j := 1; j <= j1-j0; j++ {
+			switch {
+			case eq(i, j0+j-1):
+				cur[j] = prev[j-1] + 1
+			case prev[j] >= cur[j-1]:
+				cur[j] = prev[j]
+			default:
+				cur[j] = cur[j-1]
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev
+}
+
+// lcsLengthsBack returns the length of the LCS of [i0,i1) and [j0+k,j1)
+// for each k from 0 to j1-j0.
+func lcsLengthsBack(i0, i1, j0, j1 int, eq func(i, j int) bool) []int {
+	w := j1 - j0
+	prev, cur := make([]int, w+1), make([]int, w+1)
+	for i := i1 - 1; i >= i0; i-- {
+		for j := w - 1; j >= 0; j-- {
+			switch {
+			case eq(i, j0+j):
+				cur[j] = prev[j+1] + 1
+			case prev[j] >= cur[j+1]:
+				cur[j] = prev[j]
+			default:
+				cur[j] = cur[j+1]
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev
+}
+
+// A DiffRenderer renders a Diff, using its Renderer for unchanged text.
+type DiffRenderer struct {
+	Renderer
+
+	// The following are used to bracket inserted and deleted text
+	Insert StringPair
+	Delete StringPair
+
+	// The first of the pair will be formatted with Sprintf(fmt, oldformat)
+	Restyle StringPair
+}
+
+var TextDiffRenderer = DiffRenderer{
+	Renderer: TextRenderer,
+
+	Insert: StringPair{"{+", "+}"},
+	Delete: StringPair{"[-", "-]"},
+
+	Restyle: StringPair{"{%s>", "}"},
+}
+
+var HTMLDiffRenderer = DiffRenderer{
+	Renderer: HTMLRenderer,
+
+	Insert: StringPair{"<ins>", "</ins>"},
+	Delete: StringPair{"<del>", "</del>"},
+
+	Restyle: StringPair{"<span class=\"restyle\" title=\"Was %s\">", "</span>"},
+}
+
+func (r DiffRenderer) Render(w io.Writer, d Diff) error {
+	// The text of a preview is shown as a paragraph of its own
+	block := func(n Node) Node {
+		if n.Type == Preview {
+			return Node{Type: Paragraph, Child: []Node{{Type: Text, Text: n.Text}}}
+		}
+		return n
+	}
+
+	// The blocks are numbered as one document
+	st := newRenderState()
+	render := func(w io.Writer, n Node) error {
+		return r.Renderer.render(w, n, st)
+	}
+
+	wrap := func(w io.Writer, pair StringPair, n Node) error {
+		if _, err := io.WriteString(w, pair[0]); err != nil {
+			return err
+		}
+		if err := render(w, n); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, pair[1])
+		return err
+	}
+
+	// Consecutive blocks in the same kinds of container are rendered in
+	// one of each.  A quote is rendered on its own so that it can be
+	// indented; the writers replaced are kept with the open containers.
+	var open []Node
+	var writers []io.Writer
+	enter := func(c Node) error {
+		var err error
+		switch c.Type {
+		case Quote:
+			_, err = io.WriteString(w, r.Quote[0])
+			if r.QuoteIndent != "" {
+				writers = append(writers, w)
+				w = new(bytes.Buffer)
+			}
+		case Note:
+			_, err = io.WriteString(w, r.Note[0])
+		case Verse:
+			_, err = io.WriteString(w, r.Verse[0])
+		}
+		open = append(open, c)
+		return err
+	}
+	leave := func() error {
+		c := open[len(open)-1]
+		open = open[:len(open)-1]
+
+		var err error
+		switch c.Type {
+		case Quote:
+			if r.QuoteIndent != "" {
+				b := w.(*bytes.Buffer)
+				w, writers = writers[len(writers)-1], writers[:len(writers)-1]
+				if _, err := io.WriteString(w, indent(b.String(), r.QuoteIndent)); err != nil {
+					return err
+				}
+			}
+			_, err = io.WriteString(w, r.Quote[1])
+		case Note:
+			_, err = io.WriteString(w, r.Note[1])
+		case Verse:
+			_, err = io.WriteString(w, r.Verse[1])
+		}
+		return err
+	}
+
+	for _, b := range d {
+		shared := 0
+		for shared < len(open) && shared < len(b.In) && open[shared].Type == b.In[shared].Type {
+			shared++
+		}
+		for len(open) > shared {
+			if err := leave(); err != nil {
+				return err
+			}
+		}
+		for _, c := range b.In[shared:] {
+			if err := enter(c); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		switch b.Op {
+		case Equal:
+			err = render(w, block(b.New))
+		case Insert:
+			err = wrap(w, r.Insert, block(b.New))
+		case Delete:
+			err = wrap(w, r.Delete, block(b.Old))
+		case Change:
+			err = r.renderWords(w, b.New, b.Words, render, wrap)
+		default:
+			_, err = fmt.Fprintf(w, "Unhandled %s\n", b.Op)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for len(open) > 0 {
+		if err := leave(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderWords renders the words of a changed paragraph, with the alignment
+// of its new version.  Consecutive words which changed in the same way are
+// rendered together.
+func (r DiffRenderer) renderWords(w io.Writer, para Node, words []WordDiff,
+	render func(io.Writer, Node) error, wrap func(io.Writer, StringPair, Node) error) error {
+	pair := r.Paragraph
+	switch {
+	case para.Align == Center && r.Center != (StringPair{}):
+		pair = r.Center
+	case para.Align == Right && r.Right != (StringPair{}):
+		pair = r.Right
+	}
+	if _, err := io.WriteString(w, pair[0]); err != nil {
+		return err
+	}
+
+	// Aligned text is wrapped and padded once it is all written
+	out := w
+	aligned := para.Align != Normal && r.AlignWidth > 0
+	if aligned {
+		w = new(bytes.Buffer)
+	}
+
+	for i := 0; i < len(words); {
+		first := words[i]
+
+		n := Node{Type: first.Type, Text: []byte(first.Text)}
+		j := i + 1
+		for ; j < len(words); j++ {
+			next := words[j]
+			if next.Op != first.Op || next.Type != first.Type || next.Was != first.Was {
+				break
+			}
+			if first.Type == NDash || first.Type == MDash || first.Type == LineBreak {
+				break
+			}
+			if next.Space {
+				n.Text = append(n.Text, ' ')
+			}
+			n.Text = append(n.Text, next.Text...)
+		}
+
+		if i > 0 && first.Space {
+			if _, err := io.WriteString(w, " "); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		switch first.Op {
+		case Insert:
+			err = wrap(w, r.Insert, n)
+		case Delete:
+			err = wrap(w, r.Delete, n)
+		case Restyle:
+			err = wrap(w, StringPair{fmt.Sprintf(r.Restyle[0], first.Was), r.Restyle[1]}, n)
+		default:
+			err = render(w, n)
+		}
+		if err != nil {
+			return err
+		}
+		i = j
+	}
+
+	if aligned {
+		if _, err := io.WriteString(out, align(w.(*bytes.Buffer).String(), para.Align, r.AlignWidth)); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(out, pair[1])
+	return err
+}
@@ -0,0 +1,186 @@
+package fictex
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+var diffTests = []struct {
+	Desc     string
+	Old, New string
+	Ops      []DiffOp
+	Text     string
+	HTML     string
+}{
+	{
+		Desc: "Unchanged",
+		Old:  "a b\n\nc",
+		New:  "a\nb\n\nc",
+		Ops:  []DiffOp{Equal, Equal},
+		Text: "\n    a b\n\n    c\n",
+		HTML: "<p>\na b\n</p>\n<p>\nc\n</p>\n",
+	},
+	{
+		Desc: "Inserted paragraph",
+		Old:  "a\n\nc",
+		New:  "a\n\nb\n\nc",
+		Ops:  []DiffOp{Equal, Insert, Equal},
+		Text: "\n    a\n{+\n    b\n+}\n    c\n",
+		HTML: "<p>\na\n</p>\n<ins><p>\nb\n</p>\n</ins><p>\nc\n</p>\n",
+	},
+	{
+		Desc: "Deleted rule",
+		Old:  "a\n\n-----\n\nb",
+		New:  "a\n\nb",
+		Ops:  []DiffOp{Equal, Delete, Equal},
+		Text: "\n    a\n[-\n-----\n-]\n    b\n",
+		HTML: "<p>\na\n</p>\n<del><hr />\n</del><p>\nb\n</p>\n",
+	},
+	{
+		Desc: "Replaced paragraph",
+		Old:  "one two three",
+		New:  "four five six",
+		Ops:  []DiffOp{Delete, Insert},
+		Text: "[-\n    one two three\n-]{+\n    four five six\n+}",
+	},
+	{
+		Desc: "Changed words",
+		Old:  "the quick brown fox",
+		New:  "the slow brown dog",
+		Ops:  []DiffOp{Change},
+		Text: "\n    the [-quick-] {+slow+} brown [-fox-] {+dog+}\n",
+		HTML: "<p>\nthe <del>quick</del> <ins>slow</ins> brown <del>fox</del> <ins>dog</ins>\n</p>\n",
+	},
+	{
+		Desc: "Restyled words",
+		Old:  "she was very angry",
+		New:  "she was *very angry*",
+		Ops:  []DiffOp{Change},
+		Text: "\n    she was {Text>*very angry*}\n",
+		HTML: "<p>\nshe was <span class=\"restyle\" title=\"Was Text\"><b>very angry</b></span>\n</p>\n",
+	},
+	{
+		Desc: "Changed dash",
+		Old:  "wait--what",
+		New:  "wait---what",
+		Ops:  []DiffOp{Change},
+		Text: "\n    wait{N-Dash>---}what\n",
+	},
+	{
+		Desc: "Preview",
+		Old:  "<short\nlong\n>",
+		New:  "<shorter\nlong\n>",
+		Ops:  []DiffOp{Delete, Insert, Equal},
+		Text: "[-\n    short\n-]{+\n    shorter\n+}\n    long\n",
+	},
+	{
+		Desc: "Quote and alignment",
+		Old:  "> a b\n>\n> c\n\n->  d e <-",
+		New:  "> a x\n>\n> c\n\n->  d g <-",
+		Ops:  []DiffOp{Change, Equal, Change},
+		Text: "\n        a [-b-] {+x+}\n\n        c\n\n                             d [-e-] {+g+}\n",
+		HTML: "<blockquote>\n<p>\na <del>b</del> <ins>x</ins>\n</p>\n<p>\nc\n</p>\n</blockquote>\n" +
+			"<p class=\"center\">\nd <del>e</del> <ins>g</ins>\n</p>\n",
+	},
+	{
+		Desc: "Numbering and line breaks",
+		Old:  "# H\n\na[^1] b\\\nc d\n\ne[^2]\n\n# H",
+		New:  "# H\n\na[^1] b\\\nc x\n\ne[^2]\n\n# H",
+		Ops:  []DiffOp{Equal, Change, Equal, Equal},
+		Text: "\n  H\n\n    a[1] b\n    c [-d-] {+x+}\n\n    e[2]\n\n  H\n",
+		HTML: "<h1 id=\"h\">H</h1>\n" +
+			"<p>\na<sup id=\"fnref-1\"><a href=\"#fn-1\">1</a></sup> b<br />\nc <del>d</del> <ins>x</ins>\n</p>\n" +
+			"<p>\ne<sup id=\"fnref-2\"><a href=\"#fn-2\">2</a></sup>\n</p>\n" +
+			"<h1 id=\"h-2\">H</h1>\n",
+	},
+}
+
+func TestDiff(t *testing.T) {
+	for _, test := range diffTests {
+		desc := test.Desc
+
+		d, err := DiffBytes([]byte(test.Old), []byte(test.New))
+		if err != nil {
+			t.Fatalf("%s: diff: %s", desc, err)
+		}
+
+		var ops []DiffOp
+		for _, b := range d {
+			ops = append(ops, b.Op)
+		}
+		if got, want := len(ops), len(test.Ops); got != want {
+			t.Errorf("%s: %d blocks %v, want %d %v", desc, got, ops, want, test.Ops)
+		} else {
+			for i := range ops {
+				if got, want := ops[i], test.Ops[i]; got != want {
+					t.Errorf("%s: block %d is %s, want %s", desc, i, got, want)
+				}
+			}
+		}
+
+		b := new(bytes.Buffer)
+		if err := TextDiffRenderer.Render(b, d); err != nil {
+			t.Fatalf("%s: rendertext: %s", desc, err)
+		}
+		if got, want := b.String(), test.Text; got != want {
+			t.Errorf("%s: rendertext = %q, want %q", desc, got, want)
+		}
+
+		if test.HTML == "" {
+			continue
+		}
+		b.Truncate(0)
+		if err := HTMLDiffRenderer.Render(b, d); err != nil {
+			t.Fatalf("%s: renderhtml: %s", desc, err)
+		}
+		if got, want := b.String(), test.HTML; got != want {
+			t.Errorf("%s: renderhtml = %q, want %q", desc, got, want)
+		}
+	}
+}
+
+var lcsTests = []struct {
+	A, B   string
+	Length int
+}{
+	{"", "abc", 0},
+	{"abc", "abc", 3},
+	{"abcdef", "abxdef", 5},
+	{"axbycz", "abc", 3},
+	{"abcbdab", "bdcaba", 4},
+	{"thequickbrownfox", "theslowbrowndog", 9},
+	{"aaaa", "aa", 2},
+	{"abc", "xyz", 0},
+}
+
+func TestLCS(t *testing.T) {
+	for _, test := range lcsTests {
+		a, b := test.A, test.B
+		pairs := lcs(len(a), len(b), func(i, j int) bool { return a[i] == b[j] })
+		if got, want := len(pairs), test.Length; got != want {
+			t.Errorf("lcs(%q, %q) has %d pairs %v, want %d", a, b, got, pairs, want)
+		}
+		for k, p := range pairs {
+			if a[p[0]] != b[p[1]] {
+				t.Errorf("lcs(%q, %q): pair %v does not match", a, b, p)
+			}
+			if k > 0 && (p[0] <= pairs[k-1][0] || p[1] <= pairs[k-1][1]) {
+				t.Errorf("lcs(%q, %q): pair %v is out of order", a, b, p)
+			}
+		}
+	}
+}
+
+func BenchmarkDiffLong(b *testing.B) {
+	var old []byte
+	for i := 0; i < 3000; i++ {
+		old = append(old, fmt.Sprintf("Paragraph %d of the story.\n\n", i)...)
+	}
+	new := append(append(append([]byte(nil), old[:len(old)/2]...), "An inserted paragraph.\n\n"...), old[len(old)/2:]...)
+	for i := 0; i < b.N; i++ {
+		if _, err := DiffBytes(old, new); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package fictex
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// FrontMatter is the metadata given in a header block at the start of a
+// document, like:
+//   Title: The Story
+//   Rating: PG
+//   ---
+// The block ends with a line of three dashes, or with a blank line if all
+// of its keys are known (see Config.HeaderKeys).
+type FrontMatter struct {
+	Keys   []string          // The lowercased keys in the order they appeared
+	Values map[string]string // The values of repeated keys are joined by ", "
+}
+
+// Get returns the value of the given key, ignoring case.
+func (fm FrontMatter) Get(key string) string {
+	return fm.Values[strings.ToLower(key)]
+}
+
+// Set sets the value of the given key, adding it at the end if it is new.
+func (fm *FrontMatter) Set(key, value string) {
+	key = strings.ToLower(key)
+	if fm.Values == nil {
+		fm.Values = make(map[string]string)
+	}
+	if _, ok := fm.Values[key]; !ok {
+		fm.Keys = append(fm.Keys, key)
+	}
+	fm.Values[key] = value
+}
+
+// WriteTo writes the front matter in the form in which it is parsed.
+// Nothing is written if there are no keys.
+func (fm FrontMatter) WriteTo(w io.Writer) (int64, error) {
+	if len(fm.Keys) == 0 {
+		return 0, nil
+	}
+	b := new(bytes.Buffer)
+	for _, key := range fm.Keys {
+		fmt.Fprintf(b, "%s: %s\n", strings.ToUpper(key[:1])+key[1:], fm.Values[key])
+	}
+	b.WriteString("---\n")
+	return b.WriteTo(w)
+}
+
+// MaxFrontMatter is the longest header block which will be recognized.
+const MaxFrontMatter = 4096
+
+var headerLine = regexp.MustCompile(`^([A-Za-z][-_A-Za-z0-9]{0,31})[ \t]*:[ \t]*(.*)$`)
+
+// ParseDocument parses a document which may begin with FrontMatter.  A
+// document whose first line does not look like a header, or whose header
+// block does not end properly, has no front matter.  A block which ends with
+// a blank line or the end of the document must only use the HeaderKeys of
+// DefaultConfig.
+func ParseDocument(r io.Reader) (Node, FrontMatter, error) {
+	return DefaultConfig.ParseDocument(r)
+}
+
+// ParseDocument is ParseDocument using the syntax options of the config.
+func (cfg Config) ParseDocument(r io.Reader) (Node, FrontMatter, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	fm, length, err := cfg.readFrontMatter(br)
+	if err != nil {
+		return Node{}, fm, err
+	}
+	n, err := cfg.parse(br, length)
+	return n, fm, err
+}
+
+// ParseDocumentBytes is ParseDocument for a byte slice.
+func ParseDocumentBytes(b []byte) (Node, FrontMatter, error) {
+	return ParseDocument(bytes.NewBuffer(b))
+}
+
+// readFrontMatter reads the header block, if there is one, and returns its
+// length.  Nothing is consumed if there is no front matter.
+func (cfg Config) readFrontMatter(br *bufio.Reader) (FrontMatter, int, error) {
+	var fm FrontMatter
+
+	buf, err := br.Peek(MaxFrontMatter)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return fm, 0, err
+	}
+	complete := len(buf) < MaxFrontMatter && err != bufio.ErrBufferFull
+
+	var found FrontMatter
+	for off := 0; ; {
+		line, rest := buf[off:], len(buf)
+		if i := bytes.IndexByte(line, '\n'); i >= 0 {
+			line, rest = line[:i], off+i+1
+		} else if !complete {
+			return fm, 0, nil // too long
+		}
+
+		trimmed := bytes.TrimRight(line, " \t\r")
+		if len(trimmed) == 0 || string(trimmed) == "---" {
+			if len(found.Keys) == 0 {
+				return fm, 0, nil
+			}
+			if len(trimmed) == 0 && !cfg.knownKeys(found) {
+				return fm, 0, nil
+			}
+			_, err := br.Discard(rest)
+			return found, rest, err
+		}
+
+		m := headerLine.FindSubmatch(trimmed)
+		if m == nil {
+			return fm, 0, nil
+		}
+		key, value := string(m[1]), string(m[2])
+		if prev := found.Get(key); prev != "" && value != "" {
+			value = prev + ", " + value
+		}
+		found.Set(key, value)
+
+		if off = rest; off >= len(buf) {
+			if !complete {
+				return fm, 0, nil // too long
+			}
+			// The document is nothing but headers
+			if !cfg.knownKeys(found) {
+				return fm, 0, nil
+			}
+			_, err := br.Discard(off)
+			return found, off, err
+		}
+	}
+}
+
+// knownKeys reports whether all of the keys of the front matter are in
+// HeaderKeys.
+func (cfg Config) knownKeys(fm FrontMatter) bool {
+	for _, key := range fm.Keys {
+		known := false
+		for _, k := range cfg.HeaderKeys {
+			if k == key {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,115 @@
+package fictex
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var frontMatterTests = []struct {
+	Desc   string
+	Input  string
+	Keys   []string
+	Values map[string]string
+	Output string // The rendered text of the body
+}{
+	{
+		Desc:   "No front matter",
+		Input:  "Once upon a time",
+		Output: "\n    Once upon a time\n",
+	},
+	{
+		Desc:   "Blank line",
+		Input:  "Title: A Story\nRating: PG\n\nOnce upon a time",
+		Keys:   []string{"title", "rating"},
+		Values: map[string]string{"title": "A Story", "rating": "PG"},
+		Output: "\n    Once upon a time\n",
+	},
+	{
+		Desc:   "Dashes",
+		Input:  "title:A Story\n---\nOnce upon a time",
+		Keys:   []string{"title"},
+		Values: map[string]string{"title": "A Story"},
+		Output: "\n    Once upon a time\n",
+	},
+	{
+		Desc:   "Repeated key",
+		Input:  "Characters: Alice\nCharacters: Bob\n\nHi",
+		Keys:   []string{"characters"},
+		Values: map[string]string{"characters": "Alice, Bob"},
+		Output: "\n    Hi\n",
+	},
+	{
+		Desc:   "Only headers",
+		Input:  "Title: A Story",
+		Keys:   []string{"title"},
+		Values: map[string]string{"title": "A Story"},
+	},
+	{
+		Desc:   "Not a header",
+		Input:  "Title: A Story\nOnce upon a time",
+		Output: "\n    Title: A Story Once upon a time\n",
+	},
+	{
+		Desc:   "Unknown key",
+		Input:  "Disclaimer: I own nothing\n\nOnce upon a time",
+		Output: "\n    Disclaimer: I own nothing\n\n    Once upon a time\n",
+	},
+	{
+		Desc:   "Unknown key with dashes",
+		Input:  "Title: A Story\nBeta: Bob\n---\nOnce upon a time",
+		Keys:   []string{"title", "beta"},
+		Values: map[string]string{"title": "A Story", "beta": "Bob"},
+		Output: "\n    Once upon a time\n",
+	},
+	{
+		Desc:   "Only an unknown key",
+		Input:  "Note: this is short",
+		Output: "\n    Note: this is short\n",
+	},
+	{
+		Desc:   "Starts with a rule",
+		Input:  "-----\nTitle: A Story\n",
+		Output: "\n-----\n\n    Title: A Story\n",
+	},
+}
+
+func TestFrontMatter(t *testing.T) {
+	for _, test := range frontMatterTests {
+		desc := test.Desc
+		n, fm, err := ParseDocument(strings.NewReader(test.Input))
+		if err != nil {
+			t.Fatalf("%s: parse: %s", desc, err)
+		}
+		if got, want := fm.Keys, test.Keys; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: keys = %q, want %q", desc, got, want)
+		}
+		if got, want := fm.Values, test.Values; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: values = %q, want %q", desc, got, want)
+		}
+
+		b := new(bytes.Buffer)
+		if err := TextRenderer.Render(b, n); err != nil {
+			t.Fatalf("%s: render: %s", desc, err)
+		}
+		if got, want := b.String(), test.Output; got != want {
+			t.Errorf("%s: body = %q, want %q", desc, got, want)
+		}
+
+		if len(fm.Keys) == 0 {
+			continue
+		}
+
+		// Writing the front matter back out should parse the same way
+		b.Truncate(0)
+		fm.WriteTo(b)
+		_, again, err := ParseDocument(b)
+		if err != nil {
+			t.Fatalf("%s: reparse: %s", desc, err)
+		}
+		if !reflect.DeepEqual(again, fm) {
+			t.Errorf("%s: reparsed = %#v, want %#v", desc, again, fm)
+		}
+	}
+}
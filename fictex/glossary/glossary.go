@@ -0,0 +1,368 @@
+// Package glossary finds the names of characters, places, and terms in a
+// fictex document, and the words which look like misspellings of them.
+package glossary
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"fictex"
+	"fictex/lint"
+	"fictex/stats"
+)
+
+// The kinds of entries.
+const (
+	Character = "character"
+	Place     = "place"
+	Term      = "term"
+)
+
+// An Entry is a name and the other ways it may be written.  A character
+// may also be called by any single word of its name, such as a first name
+// or a surname, unless another character shares that word.
+type Entry struct {
+	Name    string
+	Kind    string // Character, Place, or Term
+	Aliases []string
+}
+
+// A Glossary is a list of names to find in a document.
+type Glossary struct {
+	Entries []Entry
+
+	// Known reports whether a word is an ordinary word, which is never
+	// taken for a misspelled name.  It may be nil.
+	Known func(word string) bool
+
+	// WholeNames limits mentions to the names and aliases of the entries,
+	// leaving out the single words of characters' names, which may be
+	// ordinary words such as "Will" at the start of a sentence.
+	WholeNames bool
+}
+
+// A Mention is a place where a document names an entry.  Pos and End are
+// the byte offsets of the name in the source, without any possessive 's.
+type Mention struct {
+	Name     string // The Name of the entry
+	Pos, End int
+}
+
+// A NearMiss is a word which is spelled almost, but not quite, like a
+// name.  Pos and End are the byte offsets of the word in the source.
+type NearMiss struct {
+	Word     string
+	Pos, End int
+	Want     string // The spelling of the name it looks like
+	Name     string // The Name of the entry
+}
+
+// A form is one way of writing the name of an entry, as a list of words.
+type form struct {
+	words []string
+	entry int
+}
+
+// forms returns the ways the entries may be written, longest first.
+func (g *Glossary) forms() []form {
+	var out []form
+	parts := map[string][]int{}
+	for i, e := range g.Entries {
+		for _, name := range append([]string{e.Name}, e.Aliases...) {
+			if words := nameWords(name); len(words) > 0 {
+				out = append(out, form{words, i})
+			}
+		}
+		if e.Kind != Character || g.WholeNames {
+			continue
+		}
+		for _, w := range nameWords(e.Name) {
+			r, _ := utf8.DecodeRuneInString(w)
+			if utf8.RuneCountInString(w) < 3 || !unicode.IsUpper(r) {
+				continue
+			}
+			if n := len(parts[w]); n == 0 || parts[w][n-1] != i {
+				parts[w] = append(parts[w], i)
+			}
+		}
+	}
+	for w, entries := range parts {
+		if len(entries) == 1 {
+			out = append(out, form{[]string{w}, entries[0]})
+		}
+	}
+	sort.Sort(byLength(out))
+	return out
+}
+
+type byLength []form
+
+func (f byLength) Len() int      { return len(f) }
+func (f byLength) Swap(i, j int) { f[i], f[j] = f[j], f[i] }
+func (f byLength) Less(i, j int) bool {
+	if len(f[i].words) != len(f[j].words) {
+		return len(f[i].words) > len(f[j].words)
+	}
+	if f[i].entry != f[j].entry {
+		return f[i].entry < f[j].entry
+	}
+	return strings.Join(f[i].words, " ") < strings.Join(f[j].words, " ")
+}
+
+// nameWords splits a name into words as they are found in a document.
+func nameWords(name string) []string {
+	return strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\'' && r != '’'
+	})
+}
+
+// matchWord reports whether a word of a document is a word of a name.  A
+// name written in lowercase may be capitalized.
+func matchWord(name, word string) bool {
+	return word == name || name == strings.ToLower(name) && strings.ToLower(word) == name
+}
+
+// possessive returns a word without any possessive 's.
+func possessive(word string) string {
+	for _, s := range []string{"'s", "’s"} {
+		if stem := strings.TrimSuffix(word, s); stem != word && stem != "" {
+			return stem
+		}
+	}
+	return word
+}
+
+// adjacent reports whether only spaces separate two words in the source.
+func adjacent(src []byte, a, b lint.Word) bool {
+	if src == nil || a.End > b.Pos || b.Pos > len(src) {
+		return true
+	}
+	return strings.TrimSpace(string(src[a.End:b.Pos])) == ""
+}
+
+// Mentions returns the places where a document parsed from src names the
+// entries, in order.  Where names overlap, the longest is taken.
+func (g *Glossary) Mentions(n fictex.Node, src []byte) []Mention {
+	mentions, _ := g.mentions(g.forms(), lint.Words(n, src), src)
+	return mentions
+}
+
+// mentions finds the mentions of the forms of the entries in a list of
+// words, and returns which of the words they cover.
+func (g *Glossary) mentions(forms []form, words []lint.Word, src []byte) ([]Mention, []bool) {
+	covered := make([]bool, len(words))
+	var out []Mention
+	for i := 0; i < len(words); i++ {
+	forms:
+		for _, f := range forms {
+			last := i + len(f.words) - 1
+			if last >= len(words) {
+				continue
+			}
+			for j, name := range f.words {
+				w := words[i+j].Text
+				if i+j == last {
+					w = possessive(w)
+				}
+				if !matchWord(name, w) || j > 0 && !adjacent(src, words[i+j-1], words[i+j]) {
+					continue forms
+				}
+			}
+			end := endOf(words[last], len(possessive(words[last].Text)))
+			out = append(out, Mention{g.Entries[f.entry].Name, words[i].Pos, end})
+			for j := i; j <= last; j++ {
+				covered[j] = true
+			}
+			i = last
+			break
+		}
+	}
+	return out, covered
+}
+
+// NearMisses returns the words of a document parsed from src which are
+// not names but are spelled like them: a capitalized word which differs
+// from the word of a name by a letter (two in a long name), or a name
+// written with the wrong capitals.  Words shorter than three letters and
+// words which are Known are skipped.
+func (g *Glossary) NearMisses(n fictex.Node, src []byte) []NearMiss {
+	forms := g.forms()
+	words := lint.Words(n, src)
+	_, covered := g.mentions(forms, words, src)
+
+	type target struct {
+		word    string
+		name    string
+		lower   []rune
+		allowed int  // The most differences from a near miss
+		caps    bool // Whether the word has capitals
+	}
+	var targets []target
+	exact := map[string]bool{}
+	for _, f := range forms {
+		for _, w := range f.words {
+			if !exact[w] && utf8.RuneCountInString(w) >= 3 {
+				t := target{word: w, name: g.Entries[f.entry].Name, allowed: 1}
+				t.lower = []rune(strings.ToLower(w))
+				t.caps = string(t.lower) != w
+				if len(t.lower) >= 8 {
+					t.allowed = 2
+				}
+				targets = append(targets, t)
+			}
+			exact[w] = true
+		}
+	}
+	// Words of names shared by characters are names, but not mentions
+	for _, e := range g.Entries {
+		for _, w := range nameWords(e.Name) {
+			exact[w] = true
+		}
+	}
+
+	rows := new([3][]int) // Reused by distance
+	var out []NearMiss
+	for i, w := range words {
+		stem := possessive(w.Text)
+		if covered[i] || exact[stem] || utf8.RuneCountInString(stem) < 3 {
+			continue
+		}
+		if g.Known != nil && g.Known(stem) {
+			continue
+		}
+		r, _ := utf8.DecodeRuneInString(stem)
+		upper := unicode.IsUpper(r)
+		lower := []rune(strings.ToLower(stem))
+		best, bestDist := target{}, -1
+		for _, t := range targets {
+			// Only a capitalized word may be a letter or two off, and then
+			// only if its length is close enough
+			if diff := len(lower) - len(t.lower); !upper && diff != 0 ||
+				diff > t.allowed || -diff > t.allowed {
+				continue
+			}
+			d := distance(lower, t.lower, rows)
+			switch {
+			case d == 0 && !t.caps:
+				// A lowercase name may be capitalized
+				continue
+			case d == 0:
+				// The name with the wrong capitals
+			case d > t.allowed || !upper:
+				continue
+			}
+			if bestDist < 0 || d < bestDist {
+				best, bestDist = t, d
+			}
+		}
+		if bestDist >= 0 {
+			out = append(out, NearMiss{stem, w.Pos, endOf(w, len(stem)), best.word, best.name})
+		}
+	}
+	return out
+}
+
+// endOf returns the position in the source after the first n bytes of a
+// word, which is never before the word's start.
+func endOf(w lint.Word, n int) int {
+	if end := w.End - len(w.Text) + n; end > w.Pos {
+		return end
+	}
+	return w.Pos
+}
+
+// distance returns the number of runes which must be inserted, removed,
+// replaced, or swapped with the next rune to turn s into t.  Only the last
+// three rows of the table are kept, in rows, which may be reused from one
+// call to the next.
+func distance(s, t []rune, rows *[3][]int) int {
+	for k := range rows {
+		if cap(rows[k]) < len(t)+1 {
+			rows[k] = make([]int, len(t)+1)
+		}
+		rows[k] = rows[k][:len(t)+1]
+	}
+	// before, prev, and cur are the rows for i-2, i-1, and i
+	before, prev, cur := rows[0], rows[1], rows[2]
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = minimum(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				cur[j] = minimum(cur[j], before[j-2]+1)
+			}
+		}
+		before, prev, cur = prev, cur, before
+	}
+	return prev[len(t)]
+}
+
+func minimum(n int, rest ...int) int {
+	for _, m := range rest {
+		if m < n {
+			n = m
+		}
+	}
+	return n
+}
+
+// A Count is the number of times an entry is named.
+type Count struct {
+	Name     string
+	Mentions int
+}
+
+// A Cast lists the entries named in a chapter, most often named first.
+type Cast struct {
+	Title string // Empty for any text before the first chapter
+	Names []Count
+}
+
+// Casts returns the entries named in each chapter of a document parsed
+// from src.  The document is divided into chapters as by stats.Chapters.
+func (g *Glossary) Casts(n fictex.Node, src []byte) []Cast {
+	chapters := stats.Chapters(n)
+	out := make([]Cast, len(chapters))
+	counts := make([]map[string]int, len(chapters))
+	for i, ch := range chapters {
+		out[i].Title = ch.Title
+		counts[i] = map[string]int{}
+	}
+	if len(chapters) == 0 {
+		return out
+	}
+
+	ch := 0
+	for _, m := range g.Mentions(n, src) {
+		for ch+1 < len(chapters) && chapters[ch+1].Pos <= m.Pos {
+			ch++
+		}
+		if counts[ch][m.Name] == 0 {
+			out[ch].Names = append(out[ch].Names, Count{Name: m.Name})
+		}
+		counts[ch][m.Name]++
+	}
+
+	for i := range out {
+		for j := range out[i].Names {
+			out[i].Names[j].Mentions = counts[i][out[i].Names[j].Name]
+		}
+		sort.Stable(byMentions(out[i].Names))
+	}
+	return out
+}
+
+type byMentions []Count
+
+func (c byMentions) Len() int           { return len(c) }
+func (c byMentions) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c byMentions) Less(i, j int) bool { return c[i].Mentions > c[j].Mentions }
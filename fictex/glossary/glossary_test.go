@@ -0,0 +1,155 @@
+package glossary
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"fictex"
+)
+
+var testGlossary = Glossary{
+	Entries: []Entry{
+		{Name: "Elara Voss", Kind: Character, Aliases: []string{"the Captain"}},
+		{Name: "Tomas Voss", Kind: Character},
+		{Name: "Isle of Marrow", Kind: Place, Aliases: []string{"Marrow"}},
+		{Name: "bloodstone", Kind: Term},
+	},
+	Known: func(word string) bool { return word == "Then" || word == "Clear" },
+}
+
+var mentionTests = []struct {
+	Desc       string
+	Input      string
+	WholeNames bool
+	Mentions   []string // Each mention's position, end, text, and entry
+}{
+	{
+		Desc:     "Full names and parts",
+		Input:    "Elara Voss met Tomas. Elara's ship",
+		Mentions: []string{"0-10 Elara Voss = Elara Voss", "15-20 Tomas = Tomas Voss", "22-27 Elara = Elara Voss"},
+	},
+	{
+		Desc:     "Shared surname",
+		Input:    "Voss and Tomas\nVoss",
+		Mentions: []string{"9-19 Tomas\nVoss = Tomas Voss"},
+	},
+	{
+		Desc:     "Aliases and terms",
+		Input:    "The Captain found Bloodstone on the *Isle of Marrow*.",
+		Mentions: []string{"0-11 The Captain = Elara Voss", "18-28 Bloodstone = bloodstone", "37-51 Isle of Marrow = Isle of Marrow"},
+	},
+	{
+		Desc:     "Names across sentences",
+		Input:    "They left the Isle. Of Marrow, nothing.",
+		Mentions: []string{"23-29 Marrow = Isle of Marrow"},
+	},
+	{
+		Desc:       "Whole names",
+		Input:      "Elara Voss met Tomas. Elara's ship and the Captain",
+		WholeNames: true,
+		Mentions:   []string{"0-10 Elara Voss = Elara Voss", "39-50 the Captain = Elara Voss"},
+	},
+}
+
+func TestMentions(t *testing.T) {
+	for _, test := range mentionTests {
+		desc := test.Desc
+
+		fic, err := fictex.ParseString(test.Input)
+		if err != nil {
+			t.Fatalf("%s: parse: %s", desc, err)
+		}
+
+		g := testGlossary
+		g.WholeNames = test.WholeNames
+		got := map[string]bool{}
+		for _, m := range g.Mentions(fic, []byte(test.Input)) {
+			got[fmt.Sprintf("%d-%d %s = %s", m.Pos, m.End, test.Input[m.Pos:m.End], m.Name)] = true
+		}
+		want := map[string]bool{}
+		for _, m := range test.Mentions {
+			want[m] = true
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: mentions = %v, want %v", desc, got, want)
+		}
+	}
+}
+
+func TestNearMisses(t *testing.T) {
+	input := "Elera saw Tomas and Voss. Then elara's Bloodstne, Clear Marow, Tom."
+	fic, err := fictex.ParseString(input)
+	if err != nil {
+		t.Fatalf("parse: %s", err)
+	}
+
+	var got []string
+	for _, m := range testGlossary.NearMisses(fic, []byte(input)) {
+		got = append(got, fmt.Sprintf("%d-%d %s -> %s (%s)", m.Pos, m.End, input[m.Pos:m.End], m.Want, m.Name))
+	}
+	want := []string{
+		"0-5 Elera -> Elara (Elara Voss)",
+		"31-36 elara -> Elara (Elara Voss)",
+		"39-48 Bloodstne -> bloodstone (bloodstone)",
+		"56-61 Marow -> Marrow (Isle of Marrow)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NearMisses = %q, want %q", got, want)
+	}
+}
+
+func TestCasts(t *testing.T) {
+	input := "Marrow.\n\n# One\n\nElara and Tomas. Elara.\n\n## Scene\n\nTomas, Tomas.\n\n# Two\n\nNobody."
+	fic, err := fictex.ParseString(input)
+	if err != nil {
+		t.Fatalf("parse: %s", err)
+	}
+
+	got := testGlossary.Casts(fic, []byte(input))
+	want := []Cast{
+		{"", []Count{{"Isle of Marrow", 1}}},
+		{"One", []Count{{"Tomas Voss", 3}, {"Elara Voss", 2}}},
+		{"Two", nil},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Casts = %v, want %v", got, want)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		A, B string
+		Want int
+	}{
+		{"elara", "elara", 0},
+		{"elara", "elera", 1},
+		{"elara", "elraa", 1},
+		{"elara", "lara", 1},
+		{"élara", "elara", 1},
+		{"marrow", "arrows", 2},
+		{"", "abc", 3},
+		{"abc", "", 3},
+	}
+	rows := new([3][]int) // Reused, as by NearMisses
+	for _, test := range tests {
+		if got := distance([]rune(test.A), []rune(test.B), rows); got != test.Want {
+			t.Errorf("distance(%q, %q) = %d, want %d", test.A, test.B, got, test.Want)
+		}
+	}
+}
+
+func BenchmarkNearMisses(b *testing.B) {
+	var src []byte
+	for i := 0; i < 2000; i++ {
+		src = append(src, fmt.Sprintf("Elera saw the Marrows at dawn %d, and Toma said nothing of it.\n\n", i)...)
+	}
+	fic, err := fictex.ParseBytes(src)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		testGlossary.NearMisses(fic, src)
+	}
+}
@@ -0,0 +1,135 @@
+package fictex
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// JSONVersion is the version of the JSON encoding written by EncodeJSON.
+// It will change if the encoding changes in a way which older readers
+// would misunderstand; new node types or fields do not change it.
+const JSONVersion = 1
+
+// A node is encoded as an object like:
+//   {"type": "Paragraph", "pos": 0, "children": [
+//     {"type": "Text", "text": "Hello", "pos": 0}]}
+// The type is the name of the node type as printed by Node.String.  The
+// text is a UTF-8 string, and pos is the node's byte offset in the source.
+// The align (Normal, Center, or Right) and level fields are omitted when
+// they are not set, as are text and children when they are empty.
+type jsonNode struct {
+	Type     string     `json:"type"`
+	Text     string     `json:"text,omitempty"`
+	Align    string     `json:"align,omitempty"`
+	Level    int        `json:"level,omitempty"`
+	Pos      int        `json:"pos"`
+	Children []jsonNode `json:"children,omitempty"`
+}
+
+// A document is encoded as an object holding the version and its root:
+//   {"version": 1, "document": {"type": "Group", ...}}
+type jsonDocument struct {
+	Version  int      `json:"version"`
+	Document jsonNode `json:"document"`
+}
+
+// A JSONError describes a document which could not be decoded.
+type JSONError string
+
+func (e JSONError) Error() string { return "fictex: " + string(e) }
+
+// EncodeJSON writes the JSON encoding of a document.  Text which is not
+// valid UTF-8 is not preserved.
+func EncodeJSON(w io.Writer, n Node) error {
+	return json.NewEncoder(w).Encode(jsonDocument{JSONVersion, toJSON(n)})
+}
+
+// DecodeJSON reads a document written by EncodeJSON.
+func DecodeJSON(r io.Reader) (Node, error) {
+	var doc jsonDocument
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return Node{}, err
+	}
+	if doc.Version < 1 || doc.Version > JSONVersion {
+		return Node{}, JSONError(fmt.Sprintf("unsupported version %d", doc.Version))
+	}
+	return fromJSON(doc.Document)
+}
+
+// MarshalJSON encodes a single node and its children, without a version.
+func (n Node) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toJSON(n))
+}
+
+// UnmarshalJSON decodes a single node encoded by MarshalJSON.
+func (n *Node) UnmarshalJSON(b []byte) error {
+	var j jsonNode
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	node, err := fromJSON(j)
+	if err != nil {
+		return err
+	}
+	*n = node
+	return nil
+}
+
+func toJSON(n Node) jsonNode {
+	j := jsonNode{
+		Type:  n.Type.String(),
+		Text:  string(n.Text),
+		Level: n.Level,
+		Pos:   n.Pos,
+	}
+	if n.Align != Normal {
+		j.Align = n.Align.String()
+	}
+	for _, c := range n.Child {
+		j.Children = append(j.Children, toJSON(c))
+	}
+	return j
+}
+
+func fromJSON(j jsonNode) (Node, error) {
+	var n Node
+
+	typ, ok := typeNamed(j.Type)
+	if !ok {
+		return n, JSONError(fmt.Sprintf("unknown node type %q", j.Type))
+	}
+	n.Type, n.Level, n.Pos = typ, j.Level, j.Pos
+	if j.Text != "" {
+		n.Text = []byte(j.Text)
+	}
+
+	switch j.Align {
+	case "", "Normal":
+	case "Center":
+		n.Align = Center
+	case "Right":
+		n.Align = Right
+	default:
+		return n, JSONError(fmt.Sprintf("unknown alignment %q", j.Align))
+	}
+
+	for _, c := range j.Children {
+		child, err := fromJSON(c)
+		if err != nil {
+			return n, err
+		}
+		n.Child = append(n.Child, child)
+	}
+	return n, nil
+}
+
+// typeNamed returns the node type with the given name.
+func typeNamed(name string) (nodeType, bool) {
+	for t, s := range typeString {
+		if s == name {
+			return nodeType(t), true
+		}
+	}
+	return 0, false
+}
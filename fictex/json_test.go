@@ -0,0 +1,75 @@
+package fictex
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var jsonTests = []struct {
+	Desc  string
+	Input string
+	JSON  string
+}{
+	{
+		Desc:  "Paragraph",
+		Input: "a *b*",
+		JSON: `{"version":1,"document":{"type":"Group","pos":0,"children":[` +
+			`{"type":"Paragraph","pos":0,"children":[` +
+			`{"type":"Text","text":"a ","pos":0},{"type":"Bold","text":"b","pos":2}]}]}}`,
+	},
+	{
+		Desc:  "Attributes",
+		Input: "## été\n\n-> x <-",
+		JSON: `{"version":1,"document":{"type":"Group","pos":0,"children":[` +
+			`{"type":"Heading","level":2,"pos":0,"children":[{"type":"Text","text":"été","pos":3}]},` +
+			`{"type":"Paragraph","align":"Center","pos":10,"children":[{"type":"Text","text":"x","pos":13}]}]}}`,
+	},
+	{
+		Desc:  "Everything",
+		Input: "A/N: hi\n\n<short\n> q\n> -- me\n\n| v\\\n>\n\nf[^1] ^s^\n\n[^1]: n\n\n* * *",
+	},
+}
+
+func TestJSON(t *testing.T) {
+	for _, test := range jsonTests {
+		desc := test.Desc
+
+		fic, err := ParseString(test.Input)
+		if err != nil {
+			t.Fatalf("%s: parse: %s", desc, err)
+		}
+
+		b := new(bytes.Buffer)
+		if err := EncodeJSON(b, fic); err != nil {
+			t.Fatalf("%s: encode: %s", desc, err)
+		}
+		if got, want := strings.TrimSpace(b.String()), test.JSON; want != "" && got != want {
+			t.Errorf("%s: json = %s, want %s", desc, got, want)
+		}
+
+		back, err := DecodeJSON(b)
+		if err != nil {
+			t.Fatalf("%s: decode: %s", desc, err)
+		}
+		if !reflect.DeepEqual(back, fic) {
+			t.Errorf("%s: Round trip mismatch:", desc)
+			t.Logf("Got:\n%s", back)
+			t.Logf("Want:\n%s", fic)
+		}
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	for _, input := range []string{
+		`{"version":2,"document":{"type":"Group","pos":0}}`,
+		`{"version":1,"document":{"type":"Blink","pos":0}}`,
+		`{"version":1,"document":{"type":"Paragraph","align":"Justify","pos":0}}`,
+		`{"document":{"type":"Group","pos":0}}`,
+	} {
+		if n, err := DecodeJSON(strings.NewReader(input)); err == nil {
+			t.Errorf("DecodeJSON(%s) = %s, want error", input, n)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package lint
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"fictex"
+)
+
+// A document is the text of a fictex document divided into blocks, with
+// the position of every byte of text in the source.
+type document struct {
+	src       []byte
+	blocks    []*block
+	formatted []span // Bold, slant, and other formatting
+}
+
+// A block is the text of a paragraph, line of verse, heading, or footnote
+// without any markup.  Dashes are written as unicode dashes.
+type block struct {
+	text  []byte
+	pos   []int // The position in the source of each byte of text
+	fresh bool  // Whether the block follows a heading, scene break, or other break in the text
+}
+
+// A span is a formatted node, from the position of its opening marker to
+// the position where its closing marker should be.
+type span struct {
+	typ      string
+	pos, end int
+}
+
+// isFormatted reports whether a node is written between markers.
+func isFormatted(n fictex.Node) bool {
+	switch n.Type {
+	case fictex.Bold, fictex.Slant, fictex.Underline, fictex.Strike,
+		fictex.Super, fictex.SmallCaps, fictex.Spoiler:
+		return true
+	}
+	return false
+}
+
+func newDocument(n fictex.Node, src []byte) *document {
+	d := &document{src: src}
+	fresh := true
+	fictex.Walk(n, func(c *fictex.Cursor) error {
+		switch n := c.Node(); n.Type {
+		case fictex.Paragraph, fictex.Line, fictex.Attribution:
+			d.add(n.Child, fresh)
+			fresh = false
+		case fictex.Heading, fictex.Footnote:
+			d.add(n.Child, true)
+			fresh = true
+		case fictex.HLine:
+			fresh = true
+		case fictex.Verse, fictex.Quote, fictex.Preview:
+			fresh = true
+			return nil
+		default:
+			return nil
+		}
+		return fictex.SkipChildren
+	}, func(c *fictex.Cursor) error {
+		switch c.Node().Type {
+		case fictex.Verse, fictex.Quote, fictex.Preview:
+			fresh = true
+		}
+		return nil
+	})
+	return d
+}
+
+// add adds a block with the text of the inline nodes.
+func (d *document) add(inline []fictex.Node, fresh bool) {
+	b := &block{fresh: fresh}
+	for _, n := range inline {
+		switch {
+		case n.Type == fictex.Text:
+			d.text(b, string(n.Text), n.Pos)
+		case isFormatted(n):
+			end := d.text(b, string(n.Text), n.Pos+1)
+			d.formatted = append(d.formatted, span{n.Type.String(), n.Pos, end})
+		case n.Type == fictex.MDash:
+			b.mark("—", n.Pos, len("---"))
+		case n.Type == fictex.NDash:
+			b.mark("–", n.Pos, len("--"))
+		case n.Type == fictex.LineBreak:
+			b.mark("\n", n.Pos, 1)
+		}
+	}
+	d.blocks = append(d.blocks, b)
+}
+
+// maxSkip is the most bytes of source which are skipped to find the next
+// byte of text, as when the text continues on another line of a quote.
+const maxSkip = 16
+
+// text adds text found at pos in the source to a block and returns the
+// position after it.
+func (d *document) text(b *block, text string, pos int) int {
+	for i := 0; i < len(text); i++ {
+		if d.src != nil {
+			pos = d.find(text[i], pos)
+		}
+		b.text = append(b.text, text[i])
+		b.pos = append(b.pos, pos)
+		pos++
+	}
+	return pos
+}
+
+// find returns the position of c in the source at or shortly after pos, or
+// pos if it is not there.  Lines of a paragraph are joined with spaces, so
+// a newline is found for a space.  The position is always in the source,
+// even if the node the text came from had the wrong position.
+func (d *document) find(c byte, pos int) int {
+	if pos < 0 {
+		pos = 0
+	}
+	for i := pos; i < len(d.src) && i <= pos+maxSkip; i++ {
+		if d.src[i] == c || c == ' ' && d.src[i] == '\n' {
+			return i
+		}
+	}
+	if pos >= len(d.src) {
+		pos = len(d.src) - 1
+	}
+	if pos < 0 {
+		pos = 0
+	}
+	return pos
+}
+
+// within returns a span which is within a source of length n and does not
+// end before it starts.
+func within(pos, end, n int) (int, int) {
+	switch {
+	case pos < 0:
+		pos = 0
+	case pos > n:
+		pos = n
+	}
+	switch {
+	case end < pos:
+		end = pos
+	case end > n:
+		end = n
+	}
+	return pos, end
+}
+
+// mark adds text which stands for size bytes of markup at pos.
+func (b *block) mark(text string, pos, size int) {
+	for i := 0; i < len(text); i++ {
+		b.text = append(b.text, text[i])
+		b.pos = append(b.pos, pos+i*size/len(text))
+	}
+}
+
+// finding returns a finding for the text of a block from start to end.
+func (b *block) finding(start, end int, msg string) Finding {
+	return Finding{Pos: b.pos[start], End: b.pos[end-1] + 1, Message: msg}
+}
+
+// A word is a run of letters, digits, and apostrophes in the text of a
+// block, from start to end.
+type word struct {
+	text       string
+	lower      string
+	start, end int
+}
+
+func (b *block) words() []word {
+	var words []word
+	start := -1
+	text := string(b.text)
+	for i, r := range text {
+		in := unicode.IsLetter(r) || unicode.IsDigit(r) ||
+			start >= 0 && (r == '\'' || r == '’')
+		switch {
+		case in && start < 0:
+			start = i
+		case !in && start >= 0:
+			words = append(words, newWord(text, start, i))
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, newWord(text, start, len(text)))
+	}
+	return words
+}
+
+// newWord returns the word from start to end, without any apostrophes at
+// its end.
+func newWord(text string, start, end int) word {
+	for end > start {
+		r, size := utf8.DecodeLastRuneInString(text[start:end])
+		if r != '\'' && r != '’' {
+			break
+		}
+		end -= size
+	}
+	w := text[start:end]
+	return word{w, strings.ToLower(w), start, end}
+}
+
+// A Word is a word in the text of a document.  Pos and End are its byte
+// offsets in the source; if the source is not nil, 0 <= Pos <= End <=
+// len(src).
+type Word struct {
+	Text     string
+	Pos, End int
+}
+
+// Words returns the words of a document parsed from src in order, using the
+// same positions as Lint.  Words in URLs and email addresses are skipped.
+func Words(n fictex.Node, src []byte) []Word {
+	var out []Word
+	for _, b := range newDocument(n, src).blocks {
+		skip := b.addresses()
+		for _, w := range b.words() {
+			if skip(w.start) {
+				continue
+			}
+			f := b.finding(w.start, w.end, "")
+			if src != nil {
+				f.Pos, f.End = within(f.Pos, f.End, len(src))
+			}
+			out = append(out, Word{w.text, f.Pos, f.End})
+		}
+	}
+	return out
+}
+
+// addresses returns a function which reports whether a byte of the block's
+// text is part of a URL or email address.
+func (b *block) addresses() func(i int) bool {
+	var spans [][2]int
+	text := string(b.text)
+	start := -1
+	for i, r := range text + " " {
+		switch {
+		case !unicode.IsSpace(r) && start < 0:
+			start = i
+		case unicode.IsSpace(r) && start >= 0:
+			if isAddress(text[start:i]) {
+				spans = append(spans, [2]int{start, i})
+			}
+			start = -1
+		}
+	}
+	return func(i int) bool {
+		for _, s := range spans {
+			if s[0] <= i && i < s[1] {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// isAddress reports whether a run of text without spaces looks like a URL
+// or an email address.
+func isAddress(s string) bool {
+	s = strings.TrimLeft(s, "([<\"'“‘")
+	return strings.Contains(s, "://") || strings.HasPrefix(s, "www.") ||
+		strings.Contains(s, "@") && strings.Contains(s[strings.Index(s, "@"):], ".")
+}
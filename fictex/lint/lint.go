@@ -0,0 +1,352 @@
+// Package lint checks a fictex document for common mistakes in fiction,
+// such as doubled words, unbalanced quotes, and overused adverbs.
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"fictex"
+)
+
+// A Finding is a problem found by a rule.  Pos and End are the byte offsets
+// of the problem in the source of the document.
+type Finding struct {
+	Rule    string
+	Pos     int
+	End     int
+	Message string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%d: %s (%s)", f.Pos, f.Message, f.Rule)
+}
+
+// Config holds the settings of the rules and which of them are run.
+type Config struct {
+	Disabled map[string]bool // The names of rules which are not run
+
+	Openings    int      // The number of sentences in a row which may begin with the same word
+	AdverbRatio float64  // The fraction of words which may be adverbs before they are reported
+	FilterWords []string // Words which distance the reader from a character's point of view
+}
+
+// DefaultConfig runs every rule.
+var DefaultConfig = Config{
+	Openings:    2,
+	AdverbRatio: 0.02,
+	FilterWords: []string{
+		"saw", "see", "sees", "seeing", "seen",
+		"heard", "hear", "hears", "hearing",
+		"felt", "feel", "feels", "feeling",
+		"noticed", "notice", "notices", "noticing",
+		"realized", "realised", "realize", "realise", "realizes", "realises",
+		"watched", "watch", "watches", "watching",
+		"wondered", "wonder", "wonders", "wondering",
+		"seemed", "seem", "seems",
+		"decided", "decide", "decides",
+		"knew", "know", "knows",
+		"thought", "think", "thinks",
+	},
+}
+
+// A Rule checks a document for one kind of problem.
+type Rule struct {
+	Name string
+	Doc  string
+
+	check func(cfg *Config, d *document) []Finding
+}
+
+// Rules lists the rules in the order in which they are run.
+var Rules = []Rule{
+	{"doubled-word", "A word is repeated, as in \"the the\".", doubledWords},
+	{"unbalanced-quote", "A quotation is not closed before the end of its paragraph, and the next paragraph does not continue it.", unbalancedQuotes},
+	{"unbalanced-formatting", "Bold, slant, or other formatting is not closed on the line where it starts.", unbalancedFormatting},
+	{"adverb", "Adverbs ending in -ly make up more than the configured fraction of the words.", adverbs},
+	{"repeated-opening", "Too many sentences in a row begin with the same word.", repeatedOpenings},
+	{"filter-word", "A filter word, like \"saw\" or \"felt\", tells the reader what a character perceives instead of showing it.", filterWords},
+	{"dash-style", "A dash is written differently from most of the dashes in the story.", dashStyle},
+}
+
+// Lint runs the rules of DefaultConfig.
+func Lint(n fictex.Node, src []byte) []Finding {
+	return DefaultConfig.Lint(n, src)
+}
+
+// Lint checks a document parsed from src and returns the findings in order
+// of their position.  The source is used to find the exact position of each
+// finding and to see which formatting was closed; it may be nil, in which
+// case positions within text which spans the lines of a quote are
+// approximate and unbalanced formatting is not reported.  If it is not
+// nil, 0 <= Pos <= End <= len(src) for each finding, even if the nodes had
+// the wrong positions.
+func (cfg *Config) Lint(n fictex.Node, src []byte) []Finding {
+	d := newDocument(n, src)
+
+	var found []Finding
+	for _, r := range Rules {
+		if cfg.Disabled[r.Name] {
+			continue
+		}
+		for _, f := range r.check(cfg, d) {
+			f.Rule = r.Name
+			if src != nil {
+				f.Pos, f.End = within(f.Pos, f.End, len(src))
+			}
+			found = append(found, f)
+		}
+	}
+	sort.Stable(byPos(found))
+	return found
+}
+
+type byPos []Finding
+
+func (b byPos) Len() int           { return len(b) }
+func (b byPos) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byPos) Less(i, j int) bool { return b[i].Pos < b[j].Pos }
+
+// doubledWords reports words which are repeated with only space between
+// them.  "Had had" and "that that" are often correct and are not reported.
+func doubledWords(cfg *Config, d *document) []Finding {
+	var found []Finding
+	for _, b := range d.blocks {
+		words := b.words()
+		for i := 1; i < len(words); i++ {
+			prev, w := words[i-1], words[i]
+			if w.lower != prev.lower || doubledOK[w.lower] || !isSpace(b.text[prev.end:w.start]) {
+				continue
+			}
+			found = append(found, b.finding(w.start, w.end, fmt.Sprintf("%q is repeated", w.text)))
+		}
+	}
+	return found
+}
+
+var doubledOK = map[string]bool{"had": true, "that": true}
+
+// unbalancedQuotes reports double quotes which are not closed by the end of
+// their paragraph, unless the next paragraph opens with a quote to continue
+// the same speech, and closing quotes which close nothing.
+func unbalancedQuotes(cfg *Config, d *document) []Finding {
+	var found []Finding
+	for i, b := range d.blocks {
+		open := -1
+		for j, r := range string(b.text) {
+			switch {
+			case r == '"' && open < 0, r == '“', r == '«':
+				open = j
+			case r == '"', r == '”', r == '»':
+				if open < 0 {
+					found = append(found, b.finding(j, j+utf8.RuneLen(r), "closing quote without an opening quote"))
+				}
+				open = -1
+			}
+		}
+		if open < 0 {
+			continue
+		}
+		if i+1 < len(d.blocks) && !d.blocks[i+1].fresh && opensQuote(d.blocks[i+1].text) {
+			continue
+		}
+		found = append(found, b.finding(open, open+1, "quote is not closed"))
+	}
+	return found
+}
+
+func opensQuote(text []byte) bool {
+	r, _ := utf8.DecodeRune([]byte(strings.TrimLeftFunc(string(text), unicode.IsSpace)))
+	return r == '"' || r == '“' || r == '«'
+}
+
+// unbalancedFormatting reports formatting which was not closed where it
+// should have been.  The parser ends such formatting at the end of the line.
+func unbalancedFormatting(cfg *Config, d *document) []Finding {
+	if d.src == nil {
+		return nil
+	}
+	var found []Finding
+	for _, f := range d.formatted {
+		if f.pos < 0 || f.pos >= len(d.src) {
+			continue
+		}
+		if f.end < len(d.src) && d.src[f.end] == d.src[f.pos] {
+			continue
+		}
+		msg := fmt.Sprintf("%s formatting starting with %q is not closed", strings.ToLower(f.typ), d.src[f.pos])
+		found = append(found, Finding{Pos: f.pos, End: f.pos + 1, Message: msg})
+	}
+	return found
+}
+
+// adverbs reports every adverb ending in -ly if there are too many.
+func adverbs(cfg *Config, d *document) []Finding {
+	var found []Finding
+	var adverbs []string
+	total := 0
+	for _, b := range d.blocks {
+		words := b.words()
+		total += len(words)
+		for _, w := range words {
+			if isAdverb(w.lower) {
+				found = append(found, b.finding(w.start, w.end, ""))
+				adverbs = append(adverbs, w.text)
+			}
+		}
+	}
+	if total == 0 || float64(len(found))/float64(total) <= cfg.AdverbRatio {
+		return nil
+	}
+	percent := 100 * float64(len(found)) / float64(total)
+	for i := range found {
+		found[i].Message = fmt.Sprintf("adverb %q (%.1f%% of the words are adverbs)", adverbs[i], percent)
+	}
+	return found
+}
+
+// isAdverb reports whether a lowercase word looks like an adverb.
+func isAdverb(word string) bool {
+	return len(word) > 4 && strings.HasSuffix(word, "ly") && !notAdverbs[word]
+}
+
+// notAdverbs are common words ending in -ly which are not adverbs.
+var notAdverbs = map[string]bool{
+	"apply": true, "belly": true, "bully": true, "comply": true, "curly": true,
+	"daily": true, "early": true, "family": true, "friendly": true, "holy": true,
+	"jelly": true, "likely": true, "lonely": true, "lovely": true, "oily": true,
+	"reply": true, "rally": true, "silly": true, "supply": true, "ugly": true,
+	"multiply": true, "butterfly": true, "assembly": true, "italy": true,
+	"july": true, "lily": true, "only": true, "costly": true, "deadly": true,
+	"elderly": true, "lively": true, "ghostly": true, "chilly": true,
+}
+
+// repeatedOpenings reports sentences which begin with the same word as more
+// than cfg.Openings sentences before them.  Headings, scene breaks, and the
+// ends of quotes start a new run.
+func repeatedOpenings(cfg *Config, d *document) []Finding {
+	var found []Finding
+	last, run := "", 0
+	for _, b := range d.blocks {
+		if b.fresh {
+			last, run = "", 0
+		}
+		words := b.words()
+		for i, w := range words {
+			if i > 0 && !endsSentence(b.text[words[i-1].end:w.start]) {
+				continue
+			}
+			if w.lower == last {
+				run++
+			} else {
+				last, run = w.lower, 1
+			}
+			if run > cfg.Openings {
+				msg := fmt.Sprintf("%d sentences in a row begin with %q", run, w.text)
+				found = append(found, b.finding(w.start, w.end, msg))
+			}
+		}
+	}
+	return found
+}
+
+func endsSentence(gap []byte) bool {
+	return strings.ContainsAny(string(gap), ".!?…")
+}
+
+// filterWords reports each of the cfg.FilterWords.
+func filterWords(cfg *Config, d *document) []Finding {
+	filter := map[string]bool{}
+	for _, w := range cfg.FilterWords {
+		filter[strings.ToLower(w)] = true
+	}
+
+	var found []Finding
+	for _, b := range d.blocks {
+		for _, w := range b.words() {
+			if filter[w.lower] {
+				found = append(found, b.finding(w.start, w.end, fmt.Sprintf("filter word %q", w.text)))
+			}
+		}
+	}
+	return found
+}
+
+// dashStyle reports the dashes which are not written in the style used by
+// most of the dashes in the document.  An em dash may be spaced or closed
+// up, and a spaced en dash may stand in for either.  En dashes between
+// numbers and a dash which starts dialogue are not counted.
+func dashStyle(cfg *Config, d *document) []Finding {
+	type dash struct {
+		b          *block
+		start, end int
+		style      string
+	}
+	var dashes []dash
+	count := map[string]int{}
+	for _, b := range d.blocks {
+		text := string(b.text)
+		for i, r := range text {
+			if r != '—' && r != '–' {
+				continue
+			}
+			end := i + utf8.RuneLen(r)
+			if strings.TrimSpace(text[:i]) == "" {
+				continue
+			}
+			spaced := hasSpace(text[:i], true) && hasSpace(text[end:], false)
+			var style string
+			switch {
+			case r == '—' && spaced:
+				style = "spaced em dash"
+			case r == '—':
+				style = "closed em dash"
+			case spaced:
+				style = "spaced en dash"
+			default:
+				continue
+			}
+			dashes = append(dashes, dash{b, i, end, style})
+			count[style]++
+		}
+	}
+
+	// The first style to be used wins a tie
+	common := ""
+	for _, d := range dashes {
+		if count[d.style] > count[common] {
+			common = d.style
+		}
+	}
+
+	var found []Finding
+	for _, d := range dashes {
+		if d.style != common {
+			msg := fmt.Sprintf("%s, but most dashes are %ss", d.style, common)
+			found = append(found, d.b.finding(d.start, d.end, msg))
+		}
+	}
+	return found
+}
+
+// hasSpace reports whether s ends (or begins, if before is false) with a
+// space.  The end of the text counts as a space.
+func hasSpace(s string, before bool) bool {
+	if s == "" {
+		return true
+	}
+	var r rune
+	if before {
+		r, _ = utf8.DecodeLastRuneInString(s)
+	} else {
+		r, _ = utf8.DecodeRuneInString(s)
+	}
+	return unicode.IsSpace(r)
+}
+
+func isSpace(b []byte) bool {
+	return strings.TrimSpace(string(b)) == ""
+}
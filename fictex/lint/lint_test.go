@@ -0,0 +1,189 @@
+package lint
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"fictex"
+)
+
+// only returns a config which runs just the named rule.
+func only(name string) *Config {
+	cfg := DefaultConfig
+	cfg.Disabled = map[string]bool{}
+	for _, r := range Rules {
+		cfg.Disabled[r.Name] = r.Name != name
+	}
+	return &cfg
+}
+
+var lintTests = []struct {
+	Desc     string
+	Config   *Config
+	Input    string
+	Findings []string // Each finding's position, end, and text in the source
+}{
+	{
+		Desc:     "Doubled words",
+		Config:   only("doubled-word"),
+		Input:    "He saw the\nthe dog.  Well, well.  It had had *its* its day.",
+		Findings: []string{"11-14 the", "51-54 its"},
+	},
+	{
+		Desc:     "Unbalanced quotes",
+		Config:   only("unbalanced-quote"),
+		Input:    "\"One\n\n\"Two.\"\n\n\"Three\n\nFour.”",
+		Findings: []string{"14-15 \"", "27-30 ”"},
+	},
+	{
+		Desc:     "Quotes across lines",
+		Config:   only("unbalanced-quote"),
+		Input:    "> a b\n> \"c",
+		Findings: []string{"8-9 \""},
+	},
+	{
+		Desc:     "Unbalanced formatting",
+		Config:   only("unbalanced-formatting"),
+		Input:    "*a* /b\nc/ _d_\n\n=e",
+		Findings: []string{"4-5 /", "15-16 ="},
+	},
+	{
+		Desc:     "Adverbs",
+		Config:   only("adverb"),
+		Input:    "She quickly and quietly ran, only early.",
+		Findings: []string{"4-11 quickly", "16-23 quietly"},
+	},
+	{
+		Desc:     "Few adverbs",
+		Config:   func() *Config { cfg := only("adverb"); cfg.AdverbRatio = 0.5; return cfg }(),
+		Input:    "She quickly ran away from home.",
+		Findings: nil,
+	},
+	{
+		Desc:     "Repeated openings",
+		Config:   only("repeated-opening"),
+		Input:    "He ran. He hid.\n\n\"He waited,\" he said. She came.\n\n-----\n\nHe left.",
+		Findings: []string{"18-20 He"},
+	},
+	{
+		Desc:     "Filter words",
+		Config:   only("filter-word"),
+		Input:    "She felt the cold and saw /Seen/ things.",
+		Findings: []string{"4-8 felt", "22-25 saw", "27-31 Seen"},
+	},
+	{
+		Desc:     "Dash style",
+		Config:   only("dash-style"),
+		Input:    "a---b c---d e --- f 1--2\n\n--- Dialogue -- g",
+		Findings: []string{"14-17 ---", "39-41 --"},
+	},
+}
+
+func TestLint(t *testing.T) {
+	for _, test := range lintTests {
+		desc := test.Desc
+
+		fic, err := fictex.ParseString(test.Input)
+		if err != nil {
+			t.Fatalf("%s: parse: %s", desc, err)
+		}
+
+		var got []string
+		for _, f := range test.Config.Lint(fic, []byte(test.Input)) {
+			got = append(got, fmt.Sprintf("%d-%d %s", f.Pos, f.End, test.Input[f.Pos:f.End]))
+		}
+		if !reflect.DeepEqual(got, test.Findings) {
+			t.Errorf("%s: findings = %q, want %q", desc, got, test.Findings)
+		}
+	}
+}
+
+func TestLintWithoutSource(t *testing.T) {
+	input := "the the *a"
+	fic, err := fictex.ParseString(input)
+	if err != nil {
+		t.Fatalf("parse: %s", err)
+	}
+
+	var got []string
+	for _, f := range Lint(fic, nil) {
+		got = append(got, fmt.Sprintf("%d-%d %s", f.Pos, f.End, f.Rule))
+	}
+	if want := []string{"4-7 doubled-word"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("findings = %q, want %q", got, want)
+	}
+}
+
+var wrongPositionTests = []struct {
+	Desc  string
+	Input string
+	Child []fictex.Node
+}{
+	{
+		Desc:  "After the end",
+		Input: "so the the",
+		Child: []fictex.Node{{Type: fictex.Text, Text: []byte("so the the"), Pos: 6}},
+	},
+	{
+		Desc:  "Before the start",
+		Input: "so the the",
+		Child: []fictex.Node{{Type: fictex.Text, Text: []byte("so the the"), Pos: -4}},
+	},
+	{
+		Desc:  "Markup",
+		Input: "a *b* -- c",
+		Child: []fictex.Node{
+			{Type: fictex.Text, Text: []byte("a "), Pos: 0},
+			{Type: fictex.Bold, Text: []byte("b"), Pos: 20},
+			{Type: fictex.NDash, Pos: 30},
+			{Type: fictex.Text, Text: []byte(" c"), Pos: 40},
+		},
+	},
+	{
+		Desc:  "Nothing to find",
+		Input: "",
+		Child: []fictex.Node{{Type: fictex.Text, Text: []byte("so the the"), Pos: 0}},
+	},
+}
+
+func TestLintWrongPositions(t *testing.T) {
+	for _, test := range wrongPositionTests {
+		desc, src := test.Desc, []byte(test.Input)
+		fic := fictex.Node{Type: fictex.Group, Child: []fictex.Node{
+			{Type: fictex.Paragraph, Child: test.Child},
+		}}
+
+		for _, f := range Lint(fic, src) {
+			if f.Pos < 0 || f.Pos > f.End || f.End > len(src) {
+				t.Errorf("%s: finding %v at %d-%d is not within %d bytes", desc, f, f.Pos, f.End, len(src))
+			}
+		}
+		words := Words(fic, src)
+		if len(words) == 0 {
+			t.Errorf("%s: no words", desc)
+		}
+		for _, w := range words {
+			if w.Pos < 0 || w.Pos > w.End || w.End > len(src) {
+				t.Errorf("%s: word %q at %d-%d is not within %d bytes", desc, w.Text, w.Pos, w.End, len(src))
+			}
+		}
+	}
+}
+
+func TestWords(t *testing.T) {
+	input := "> Don't *go*\n> see http://a.example/b_c/ or me@example.com."
+	fic, err := fictex.ParseString(input)
+	if err != nil {
+		t.Fatalf("parse: %s", err)
+	}
+
+	var got []string
+	for _, w := range Words(fic, []byte(input)) {
+		got = append(got, fmt.Sprintf("%d-%d %s", w.Pos, w.End, input[w.Pos:w.End]))
+	}
+	want := []string{"2-7 Don't", "9-11 go", "15-18 see", "41-43 or"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Words = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,57 @@
+package fictex
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A Section is a heading in a document's outline.
+type Section struct {
+	Level int
+	Title string
+	ID    string // The id of the heading's anchor in HTML
+}
+
+// Outline returns the headings of a document in order.
+func Outline(n Node) []Section {
+	var out []Section
+	ids := anchors{}
+	for _, h := range Find(n, Heading) {
+		title := TextContent(h)
+		out = append(out, Section{h.Level, title, ids.id(title)})
+	}
+	return out
+}
+
+// anchors generates the ids of headings, which are made unique within a
+// document by numbering repeats.  It holds the ids already used.
+type anchors map[string]bool
+
+func (a anchors) id(title string) string {
+	var b []rune
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && len(b) > 0 {
+				b = append(b, '-')
+			}
+			b = append(b, r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	id := string(b)
+	if id == "" {
+		id = "section"
+	}
+
+	// A repeat may itself look like a numbered id, as "a 2" does
+	base := id
+	for n := 2; a[id]; n++ {
+		id = base + "-" + strconv.Itoa(n)
+	}
+	a[id] = true
+	return id
+}
@@ -0,0 +1,59 @@
+package fictex
+
+import (
+	"reflect"
+	"testing"
+)
+
+var outlineTests = []struct {
+	Desc    string
+	Input   string
+	Outline []Section
+}{
+	{
+		Desc:  "No headings",
+		Input: "text\n\n-----\n\nmore",
+	},
+	{
+		Desc:  "Parts and chapters",
+		Input: "# Part One: *Winter*\n\n## I\n\ntext\n\n## II\n\n# Part Two -- Spring\n\n## I",
+		Outline: []Section{
+			{1, "Part One: Winter", "part-one-winter"},
+			{2, "I", "i"},
+			{2, "II", "ii"},
+			{1, "Part Two – Spring", "part-two-spring"},
+			{2, "I", "i-2"},
+		},
+	},
+	{
+		Desc:  "Numbered repeats",
+		Input: "# a\n\n# a 2\n\n# a\n\n# a",
+		Outline: []Section{
+			{1, "a", "a"},
+			{1, "a 2", "a-2"},
+			{1, "a", "a-3"},
+			{1, "a", "a-4"},
+		},
+	},
+	{
+		Desc:  "In a preview",
+		Input: "<short\n# ???\n>",
+		Outline: []Section{
+			{1, "???", "section"},
+		},
+	},
+}
+
+func TestOutline(t *testing.T) {
+	for _, test := range outlineTests {
+		desc := test.Desc
+
+		fic, err := ParseString(test.Input)
+		if err != nil {
+			t.Fatalf("%s: parse: %s", desc, err)
+		}
+		if got, want := Outline(fic), test.Outline; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: outline = %v, want %v", desc, got, want)
+		}
+	}
+}
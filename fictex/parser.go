@@ -20,25 +20,61 @@ const (
 	Bold
 	Slant
 	Underline
-	MDash   // No Text or Child
-	NDash   // No Text or Child
-	HLine   // No Text or Child
-	Preview // Text is the preview, Children are the full
+	MDash       // No Text or Child
+	NDash       // No Text or Child
+	HLine       // Text is the scene break glyph, if any; no Child
+	Preview     // Text is the preview, Children are the full
+	FootnoteRef // Text is the label of the footnote
+	Footnote    // Text is the label, Children are the footnote's text
+	Note        // An author's note; Children are its paragraphs
+	Quote       // A block quote; Children are its paragraphs
+	Attribution // The author of a quote; Children are its text
+	Verse       // Lines of poetry; Children are Lines
+	Line        // Text is the indentation, Children are the line's text
+	LineBreak   // No Text or Child
+	Strike
+	Super
+	SmallCaps
+	Spoiler
+	Heading // Children are the heading's text
 )
 
 var typeString = [...]string{
 	"Group", "Text", "Paragraph", "Bold", "Slant",
 	"Underline", "M-Dash", "N-Dash", "Separator", "Preview",
+	"Footnote-Ref", "Footnote", "Note", "Quote", "Attribution",
+	"Verse", "Line", "Line-Break", "Strike", "Super",
+	"Small-Caps", "Spoiler", "Heading",
 }
 
 func (t nodeType) String() string {
 	return typeString[t]
 }
 
+// An Alignment is the alignment of a Paragraph.
+type Alignment int
+
+const (
+	Normal Alignment = iota // Aligned like the rest of the document
+	Center
+	Right
+)
+
+var alignString = [...]string{
+	"Normal", "Center", "Right",
+}
+
+func (a Alignment) String() string {
+	return alignString[a]
+}
+
 type Node struct {
 	Type  nodeType
 	Text  []byte
 	Child []Node
+	Align Alignment // Paragraph only
+	Level int       // Heading only, from 1 to MaxLevel
+	Pos   int       // The byte offset in the source where the node starts
 }
 
 func (n Node) String() string {
@@ -48,14 +84,21 @@ func (n Node) String() string {
 }
 
 func (n Node) str(w io.Writer, depth int) {
-	indent := strings.Repeat("| ", depth)
-	fmt.Fprintf(w, "%s+ %s:\n", indent, n.Type)
-	if len(n.Text) > 0 {
-		fmt.Fprintf(w, "%s| + %q\n", indent, n.Text)
-	}
-	for _, c := range n.Child {
-		c.str(w, depth+1)
-	}
+	Walk(n, func(c *Cursor) error {
+		n, indent := c.Node(), strings.Repeat("| ", depth+c.Depth())
+		switch {
+		case n.Align != Normal:
+			fmt.Fprintf(w, "%s+ %s (%s):\n", indent, n.Type, n.Align)
+		case n.Level > 0:
+			fmt.Fprintf(w, "%s+ %s (%d):\n", indent, n.Type, n.Level)
+		default:
+			fmt.Fprintf(w, "%s+ %s:\n", indent, n.Type)
+		}
+		if len(n.Text) > 0 {
+			fmt.Fprintf(w, "%s| + %q\n", indent, n.Text)
+		}
+		return nil
+	}, nil)
 }
 
 func ParseBytes(b []byte) (Node, error) {
@@ -66,12 +109,45 @@ func ParseString(s string) (Node, error) {
 	return Parse(strings.NewReader(s))
 }
 
+// A Config controls the optional parts of the syntax.
+type Config struct {
+	// SceneBreaks lists the lines which are scene breaks (HLine nodes) in
+	// addition to lines of five or more dashes.  Leading and trailing
+	// whitespace on the line is ignored.
+	SceneBreaks []string
+
+	// HeaderKeys lists the keys, in lowercase, of a header block which may
+	// end with an empty line.  A block with any other key must end with a
+	// line of ---, so that a first paragraph like "Disclaimer: ..." is not
+	// taken for headers.
+	HeaderKeys []string
+}
+
+// DefaultConfig is used by Parse.
+var DefaultConfig = Config{
+	SceneBreaks: []string{
+		"* * *", "***", "~", "~~~", "o0o", "0o0", "#",
+		"\u2766", "\u2042", "\u00a7",
+	},
+	HeaderKeys: []string{
+		"title", "author", "rating", "fandom", "characters", "relationships",
+		"warnings", "tags", "dictionary", "language", "typography", "summary",
+	},
+}
+
 func Parse(r io.Reader) (Node, error) {
-	br, ok := r.(*bufio.Reader)
-	if !ok {
-		br = bufio.NewReader(r)
-	}
-	p := parser{br}
+	return DefaultConfig.Parse(r)
+}
+
+// Parse parses a document using the syntax options of the config.
+func (cfg Config) Parse(r io.Reader) (Node, error) {
+	return cfg.parse(r, 0)
+}
+
+// parse parses a document whose first byte is at offset base in its source.
+func (cfg Config) parse(r io.Reader, base int) (Node, error) {
+	cr := &countingReader{r: r, n: base}
+	p := parser{bufio.NewReader(cr), &cfg, cr}
 
 	var (
 		n, m Node
@@ -85,11 +161,56 @@ func Parse(r io.Reader) (Node, error) {
 	if err == io.EOF {
 		err = nil
 	}
+	n.Child = arrangeNotes(n.Child)
 	return n, err
 }
 
+// arrangeNotes merges consecutive author's notes and moves those which are
+// not at the start of the document to the end.
+func arrangeNotes(nodes []Node) []Node {
+	var before, body, after []Node
+	for _, n := range nodes {
+		if n.Type != Note {
+			body = append(body, n)
+			continue
+		}
+		notes := &after
+		if len(body) == 0 {
+			notes = &before
+		}
+		if last := len(*notes) - 1; last >= 0 {
+			(*notes)[last].Child = append((*notes)[last].Child, n.Child...)
+			continue
+		}
+		*notes = append(*notes, n)
+	}
+	if len(before) == 0 && len(after) == 0 {
+		return nodes
+	}
+	return append(append(before, body...), after...)
+}
+
 type parser struct {
 	*bufio.Reader
+	cfg *Config
+	src *countingReader
+}
+
+// A countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += n
+	return n, err
+}
+
+// offset returns the position of the next byte to be read.
+func (p *parser) offset() int {
+	return p.src.n - p.Buffered()
 }
 
 func (p *parser) top() (Node, error) {
@@ -101,6 +222,7 @@ func (p *parser) top() (Node, error) {
 		if err != nil {
 			return n, err
 		}
+		start := p.offset() - 1
 
 		var next Node
 		switch c {
@@ -108,10 +230,17 @@ func (p *parser) top() (Node, error) {
 			continue // slurp whitespace
 		case '<':
 			next, err = p.readPreview()
+		case '>':
+			p.UnreadByte()
+			next, err = p.readQuote(false)
+		case '|':
+			p.UnreadByte()
+			next, err = p.readVerse()
 		default:
 			p.UnreadByte()
 			next, err = p.readParagraph(false)
 		}
+		next.Pos = start
 
 		n.Child = append(n.Child, next)
 
@@ -151,11 +280,20 @@ more:
 		if err != nil {
 			return n, err
 		}
+		start := p.offset() - 1
 
 		var next Node
 		switch c {
 		case '>':
-			break more
+			p.UnreadByte()
+			if p.endsPreview() {
+				p.ReadByte()
+				break more
+			}
+			next, err = p.readQuote(true)
+		case '|':
+			p.UnreadByte()
+			next, err = p.readVerse()
 		case '\n', '\t', ' ':
 			continue // slurp whitespace
 		case '<':
@@ -164,6 +302,7 @@ more:
 			p.UnreadByte()
 			next, err = p.readParagraph(true)
 		}
+		next.Pos = start
 
 		n.Child = append(n.Child, next)
 		if err != nil {
@@ -174,8 +313,349 @@ more:
 	return n, nil
 }
 
+// readQuote reads a block quote, in which each line starts with a >:
+//   > Dear John,
+//   >
+//   > I /miss/ you.
+//   > -- Mary
+// The quote may contain any blocks, including other quotes.  If the last
+// paragraph starts with a dash, it is the attribution.  In a preview, a >
+// on its own line which is not followed by another line of the quote ends
+// the preview instead.
+func (p *parser) readQuote(preview bool) (Node, error) {
+	n := Node{Type: Quote}
+
+	// The offsets of the start of each line in src and in the document
+	var src []byte
+	var lines [][2]int
+	var err error
+	for {
+		if c, _ := p.Peek(1); len(c) == 0 || c[0] != '>' {
+			break
+		}
+		if preview && p.endsPreview() {
+			break
+		}
+		p.ReadByte()
+		if c, _ := p.Peek(1); len(c) > 0 && c[0] == ' ' {
+			p.ReadByte()
+		}
+		lines = append(lines, [2]int{len(src), p.offset()})
+
+		var line []byte
+		line, err = p.ReadBytes('\n')
+		src = append(src, line...)
+		if err != nil {
+			break
+		}
+	}
+
+	inner, perr := p.cfg.Parse(bytes.NewBuffer(src))
+	if perr != nil {
+		return n, perr
+	}
+	inner, _ = Walk(inner, func(c *Cursor) error {
+		n := c.Node()
+		for i := len(lines) - 1; i >= 0; i-- {
+			if lines[i][0] <= n.Pos {
+				n.Pos += lines[i][1] - lines[i][0]
+				break
+			}
+		}
+		c.Replace(n)
+		return nil
+	}, nil)
+	n.Child = inner.Child
+
+	if last := len(n.Child) - 1; last >= 0 && n.Child[last].Type == Paragraph {
+		if para := n.Child[last]; len(para.Child) > 0 {
+			if t := para.Child[0].Type; t == NDash || t == MDash {
+				para.Type = Attribution
+				para.Child = para.Child[1:]
+				trimFirst(&para)
+				n.Child[last] = para
+			}
+		}
+	}
+	return n, err
+}
+
+// readVerse reads lines of verse, each of which starts with a |:
+//   | Roses are red,
+//   |     /violets/ are blue
+//   |
+//   | Another stanza
+// A single space after the | is ignored; any other leading whitespace is
+// kept as the indentation of the line.
+func (p *parser) readVerse() (Node, error) {
+	n := Node{Type: Verse}
+
+	for {
+		if c, _ := p.Peek(1); len(c) == 0 || c[0] != '|' {
+			return n, nil
+		}
+		line := Node{Type: Line, Pos: p.offset()}
+		p.ReadByte()
+		if c, _ := p.Peek(1); len(c) > 0 && c[0] == ' ' {
+			p.ReadByte()
+		}
+
+		start := p.offset()
+		raw, err := p.ReadBytes('\n')
+		raw = bytes.TrimRight(raw, " \t\r\n")
+		text := bytes.TrimLeft(raw, " \t")
+		indent := bytes.Replace(raw[:len(raw)-len(text)], []byte{'\t'}, []byte("    "), -1)
+
+		if len(indent) > 0 {
+			line.Text = indent
+		}
+		if len(text) > 0 {
+			line.Child = inline(text, start+len(raw)-len(text))
+		}
+		n.Child = append(n.Child, line)
+
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// inline parses text as the contents of a single line which starts at
+// offset base.
+func inline(text []byte, base int) []Node {
+	src := &countingReader{r: bytes.NewBuffer(text), n: base}
+	sub := parser{bufio.NewReader(src), &DefaultConfig, src}
+
+	var nodes []Node
+	for {
+		pos := sub.offset()
+		next, err := sub.readText()
+		next.Pos = pos
+		if next.Type != Text || len(next.Text) > 0 {
+			if last := len(nodes) - 1; last >= 0 && next.Type == Text && nodes[last].Type == Text {
+				nodes[last].Text = append(nodes[last].Text, next.Text...)
+			} else {
+				nodes = append(nodes, next)
+			}
+		}
+		if err != nil {
+			return nodes
+		}
+		if _, err := sub.Peek(1); err != nil {
+			return nodes
+		}
+	}
+}
+
+// endsPreview returns true if the next line is a > on its own which is not
+// followed by a line starting with >.
+func (p *parser) endsPreview() bool {
+	buf, err := p.Peek(64)
+	if len(buf) == 0 || buf[0] != '>' {
+		return false
+	}
+	for i, c := range buf[1:] {
+		switch c {
+		case ' ', '\t', '\r':
+			continue
+		case '\n':
+			return i+2 >= len(buf) || buf[i+2] != '>'
+		}
+		return false
+	}
+	return err != nil
+}
+
+// NoteMarker starts a paragraph which is an author's note.
+const NoteMarker = "A/N:"
+
+// readParagraph reads a paragraph, which may be an author's note (when it
+// starts with NoteMarker) or the definition of a footnote, like:
+//   [^label]: The text of the footnote
 func (p *parser) readParagraph(preview bool) (Node, error) {
-	n := Node{Type: Paragraph}
+	if glyph := p.readSceneBreak(); glyph != nil {
+		return Node{Type: HLine, Text: glyph}, nil
+	}
+	if level := p.headingLevel(); level > 0 {
+		return p.readHeading(level)
+	}
+	if prefix, _ := p.Peek(len(NoteMarker)); string(prefix) == NoteMarker {
+		p.Discard(len(NoteMarker))
+		n, err := p.readLines(preview)
+		trimFirst(&n)
+		return Node{Type: Note, Child: []Node{n}}, err
+	}
+	if label := p.readLabel(true); label != "" {
+		n, err := p.readLines(preview)
+		n.Type, n.Text = Footnote, []byte(label)
+		trimFirst(&n)
+		return n, err
+	}
+	if prefix, _ := p.Peek(len(AlignMarker)); string(prefix) == AlignMarker {
+		pos := p.offset()
+		p.Discard(len(AlignMarker))
+		n, err := p.readLines(preview)
+		n.Pos = pos
+		switch {
+		case trimLast(&n, CenterMarker):
+			n.Align = Center
+		case trimLast(&n, AlignMarker):
+			n.Align = Right
+		case len(n.Child) > 0 && n.Child[0].Type == Text:
+			// Without a closing marker, the marker is part of the text
+			n.Child[0].Text = append([]byte(AlignMarker), n.Child[0].Text...)
+			n.Child[0].Pos = pos
+			return n, err
+		default:
+			n.Child = append([]Node{{Type: Text, Text: []byte(AlignMarker), Pos: pos}}, n.Child...)
+			return n, err
+		}
+		trimFirst(&n)
+		return n, err
+	}
+	return p.readLines(preview)
+}
+
+// MaxLevel is the number of levels of headings.
+const MaxLevel = 6
+
+// headingLevel returns the level of the heading which starts here, if any:
+// one # for each level, followed by a space.
+func (p *parser) headingLevel() int {
+	buf, _ := p.Peek(MaxLevel + 1)
+	level := 0
+	for level < len(buf) && buf[level] == '#' {
+		level++
+	}
+	if level == 0 || level > MaxLevel || level == len(buf) || buf[level] != ' ' {
+		return 0
+	}
+	return level
+}
+
+// readHeading reads a heading, which is a single line like:
+//   ## Part One: Winter
+// Any #s at the end of the line are ignored.
+func (p *parser) readHeading(level int) (Node, error) {
+	n := Node{Type: Heading, Level: level}
+
+	p.Discard(level)
+	start := p.offset()
+	raw, err := p.ReadBytes('\n')
+	if err == io.EOF {
+		err = nil
+	}
+	line := bytes.TrimSpace(raw)
+	if trimmed := bytes.TrimRight(line, "#"); len(trimmed) < len(line) {
+		line = bytes.TrimSpace(trimmed)
+	}
+	n.Child = inline(line, start+bytes.Index(raw, line))
+	return n, err
+}
+
+// A paragraph between AlignMarker and CenterMarker is centered, and one
+// between two AlignMarkers is right-aligned.
+const (
+	AlignMarker  = "->"
+	CenterMarker = "<-"
+)
+
+// readSceneBreak reads a line which is one of the configured scene breaks
+// and returns it without surrounding whitespace.  Nothing is read if the
+// line is not a scene break.
+func (p *parser) readSceneBreak() []byte {
+	if len(p.cfg.SceneBreaks) == 0 {
+		return nil
+	}
+
+	buf, err := p.Peek(64)
+	length := bytes.IndexByte(buf, '\n') + 1
+	if length == 0 {
+		if err == nil {
+			return nil // too long
+		}
+		length = len(buf)
+	}
+
+	line := bytes.TrimSpace(buf[:length])
+	for _, glyph := range p.cfg.SceneBreaks {
+		if string(line) == glyph {
+			p.Discard(length)
+			return []byte(glyph)
+		}
+	}
+	return nil
+}
+
+// trimFirst trims leading spaces from the first child of n, moving its
+// position past them.
+func trimFirst(n *Node) {
+	if len(n.Child) == 0 || n.Child[0].Type != Text {
+		return
+	}
+	text := bytes.TrimLeft(n.Child[0].Text, " ")
+	n.Child[0].Pos += len(n.Child[0].Text) - len(text)
+	n.Child[0].Text = text
+	if len(n.Child[0].Text) == 0 {
+		n.Child = n.Child[1:]
+	}
+}
+
+// trimLast removes suffix and any surrounding spaces from the end of the
+// last child of n, and returns whether it was there.  The child's position
+// is its start, which does not move; a child which is left empty is
+// removed.
+func trimLast(n *Node, suffix string) bool {
+	last := len(n.Child) - 1
+	if last < 0 || n.Child[last].Type != Text {
+		return false
+	}
+	text := bytes.TrimRight(n.Child[last].Text, " \n")
+	if !bytes.HasSuffix(text, []byte(suffix)) {
+		return false
+	}
+	n.Child[last].Text = bytes.TrimRight(text[:len(text)-len(suffix)], " \n")
+	if len(n.Child[last].Text) == 0 {
+		n.Child = n.Child[:last]
+	}
+	return true
+}
+
+// readLabel reads a footnote label like [^label] and returns the label.  If
+// def is true, the label must be followed by a colon, which is also read.
+// Nothing is read if there is no label.
+func (p *parser) readLabel(def bool) string {
+	const maxLabel = 32
+
+	buf, _ := p.Peek(maxLabel + 4)
+	if len(buf) < 4 || buf[0] != '[' || buf[1] != '^' {
+		return ""
+	}
+	end := bytes.IndexByte(buf, ']')
+	if end < 3 {
+		return ""
+	}
+	label := buf[2:end]
+	if bytes.IndexAny(label, " \t\n[") >= 0 {
+		return ""
+	}
+
+	length := end + 1
+	if def {
+		if len(buf) <= length || buf[length] != ':' {
+			return ""
+		}
+		length++
+	}
+
+	s := string(label)
+	p.Discard(length)
+	return s
+}
+
+// readLines reads the lines of a paragraph up to the next empty line.
+func (p *parser) readLines(preview bool) (Node, error) {
+	n := Node{Type: Paragraph, Pos: p.offset()}
 
 	// Check for dashes
 	c, err := p.ReadByte()
@@ -185,6 +665,7 @@ func (p *parser) readParagraph(preview bool) (Node, error) {
 
 	if c == '-' {
 		node, err := p.readDash()
+		node.Pos = n.Pos
 		if err != nil {
 			return node, err
 		}
@@ -203,8 +684,8 @@ func (p *parser) readParagraph(preview bool) (Node, error) {
 			break
 		}
 
-		// End a preview with a > on its own line
-		if preview && c == '>' {
+		// End a preview with a > on its own line, or start a quote or verse
+		if c == '>' || c == '|' {
 			p.UnreadByte()
 			break
 		}
@@ -217,7 +698,9 @@ func (p *parser) readParagraph(preview bool) (Node, error) {
 		p.UnreadByte()
 
 	more:
+		pos := p.offset()
 		next, err := p.readText()
+		next.Pos = pos
 
 		eol := false
 		if length := len(next.Text); length > 0 && next.Text[length-1] == '\n' {
@@ -225,8 +708,15 @@ func (p *parser) readParagraph(preview bool) (Node, error) {
 			eol = true
 		}
 
+		// A backslash at the end of a line is a hard line break
+		brk := false
+		if eol && next.Type == Text && bytes.HasSuffix(next.Text, []byte("\\\n")) {
+			next.Text = next.Text[:len(next.Text)-2]
+			brk = true
+		}
+
 		if next.Type != Text || len(next.Text) > 0 {
-			if last != nil && next.Type == last.Type {
+			if last != nil && next.Type == last.Type && next.Type != FootnoteRef {
 				last.Text = append(last.Text, next.Text...)
 				last.Child = append(last.Child, next.Child...)
 			} else {
@@ -234,6 +724,10 @@ func (p *parser) readParagraph(preview bool) (Node, error) {
 				last = &n.Child[len(n.Child)-1]
 			}
 		}
+		if brk {
+			n.Child = append(n.Child, Node{Type: LineBreak})
+			last = &n.Child[len(n.Child)-1]
+		}
 
 		if err != nil {
 			break
@@ -256,7 +750,8 @@ func (p *parser) readParagraph(preview bool) (Node, error) {
 // readText reads a "normal" piece of text:
 //   - Formatted if it starts with / * or _
 //   - As a dash if it starts with -
-//   - Up to the next dash, space, or newline otherwise
+//   - As a footnote reference if it starts with [^
+//   - Up to the next dash, bracket, space, or newline otherwise
 func (p *parser) readText() (Node, error) {
 	n := Node{Type: Text}
 
@@ -269,9 +764,32 @@ func (p *parser) readText() (Node, error) {
 	switch start {
 	case '-':
 		return p.readDash()
+	case '^':
+		p.UnreadByte()
+		if !p.closedWord(start) {
+			p.ReadByte()
+			return Node{Type: Text, Text: []byte{start}}, nil
+		}
+		return p.readFormatted()
+	case '~', '=', '!':
+		// These are common enough in prose that they only start a style
+		// when they are followed by the styled text
+		p.UnreadByte()
+		if next, _ := p.Peek(2); len(next) < 2 || unicode.IsSpace(rune(next[1])) {
+			p.ReadByte()
+			return Node{Type: Text, Text: []byte{start}}, nil
+		}
+		return p.readFormatted()
 	case '/', '*', '_':
 		p.UnreadByte()
 		return p.readFormatted()
+	case '[':
+		p.UnreadByte()
+		if label := p.readLabel(false); label != "" {
+			return Node{Type: FootnoteRef, Text: []byte(label)}, nil
+		}
+		p.ReadByte()
+		return Node{Type: Text, Text: []byte{'['}}, nil
 	default:
 		p.UnreadByte()
 		start = 0
@@ -282,7 +800,8 @@ func (p *parser) readText() (Node, error) {
 		if err != nil {
 			break
 		}
-		if c == '-' {
+		// Superscripts may start within a word, like 1^st^
+		if c == '-' || c == '[' || c == '^' {
 			p.UnreadByte()
 			break
 		}
@@ -310,6 +829,14 @@ func (p *parser) readFormatted() (Node, error) {
 		n.Type = Slant
 	case '_':
 		n.Type = Underline
+	case '~':
+		n.Type = Strike
+	case '^':
+		n.Type = Super
+	case '=':
+		n.Type = SmallCaps
+	case '!':
+		n.Type = Spoiler
 	default:
 		// Shouldn't happen, but...
 		start = 0
@@ -344,6 +871,21 @@ func (p *parser) readFormatted() (Node, error) {
 	return n, nil
 }
 
+// closedWord returns true if the next byte, c, is closed again by another
+// c before the end of the word.
+func (p *parser) closedWord(c byte) bool {
+	buf, _ := p.Peek(64)
+	for i := 1; i < len(buf); i++ {
+		switch {
+		case buf[i] == c:
+			return i > 1
+		case unicode.IsSpace(rune(buf[i])):
+			return false
+		}
+	}
+	return false
+}
+
 func (p *parser) readDash() (Node, error) {
 	cnt := 1
 
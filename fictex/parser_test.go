@@ -245,6 +245,401 @@ var parseTests = []struct {
 			}},
 		},
 	},
+	{
+		Desc:  "Inline Styles",
+		Input: "~gone~ =EXIT= the 1^st^ !dies! 2 ^ 3 = 5!",
+		Output: Node{
+			Type: Group,
+			Child: []Node{{
+				Type: Paragraph,
+				Child: []Node{{
+					Type: Strike,
+					Text: []byte("gone"),
+				}, {
+					Type: Text,
+					Text: []byte(" "),
+				}, {
+					Type: SmallCaps,
+					Text: []byte("EXIT"),
+				}, {
+					Type: Text,
+					Text: []byte(" the 1"),
+				}, {
+					Type: Super,
+					Text: []byte("st"),
+				}, {
+					Type: Text,
+					Text: []byte(" "),
+				}, {
+					Type: Spoiler,
+					Text: []byte("dies"),
+				}, {
+					Type: Text,
+					Text: []byte(" 2 ^ 3 = 5!"),
+				}},
+			}},
+		},
+	},
+	{
+		Desc:  "Alignment",
+		Input: "-> Title <-\n\n->/Love,/\nMe ->\n\n-> not",
+		Output: Node{
+			Type: Group,
+			Child: []Node{{
+				Type:  Paragraph,
+				Align: Center,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("Title"),
+				}},
+			}, {
+				Type:  Paragraph,
+				Align: Right,
+				Child: []Node{{
+					Type: Slant,
+					Text: []byte("Love,"),
+				}, {
+					Type: Text,
+					Text: []byte(" Me"),
+				}},
+			}, {
+				Type: Paragraph,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("-> not"),
+				}},
+			}},
+		},
+	},
+	{
+		Desc:  "Headings",
+		Input: "# Part /One/ #\ntext\n\n###### Six\n\n####### Seven\n\n#1",
+		Output: Node{
+			Type: Group,
+			Child: []Node{{
+				Type:  Heading,
+				Level: 1,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("Part "),
+				}, {
+					Type: Slant,
+					Text: []byte("One"),
+				}},
+			}, {
+				Type: Paragraph,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("text"),
+				}},
+			}, {
+				Type:  Heading,
+				Level: 6,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("Six"),
+				}},
+			}, {
+				Type: Paragraph,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("####### Seven"),
+				}},
+			}, {
+				Type: Paragraph,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("#1"),
+				}},
+			}},
+		},
+	},
+	{
+		Desc:  "Scene Breaks",
+		Input: "a\n\n  * * *  \n\nb\n\n\u2766\n\no0o c",
+		Output: Node{
+			Type: Group,
+			Child: []Node{{
+				Type: Paragraph,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("a"),
+				}},
+			}, {
+				Type: HLine,
+				Text: []byte("* * *"),
+			}, {
+				Type: Paragraph,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("b"),
+				}},
+			}, {
+				Type: HLine,
+				Text: []byte("\u2766"),
+			}, {
+				Type: Paragraph,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("o0o c"),
+				}},
+			}},
+		},
+	},
+	{
+		Desc:  "Footnotes",
+		Input: "a[^1] [b] [^x y]\n\n[^1]: note",
+		Output: Node{
+			Type: Group,
+			Child: []Node{{
+				Type: Paragraph,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("a"),
+				}, {
+					Type: FootnoteRef,
+					Text: []byte("1"),
+				}, {
+					Type: Text,
+					Text: []byte(" [b] [^x y]"),
+				}},
+			}, {
+				Type: Footnote,
+				Text: []byte("1"),
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("note"),
+				}},
+			}},
+		},
+	},
+	{
+		Desc:  "Author's Notes",
+		Input: "A/N: first\n\nA/N: second\n\nstory\n\nA/N: /last/",
+		Output: Node{
+			Type: Group,
+			Child: []Node{{
+				Type: Note,
+				Child: []Node{{
+					Type: Paragraph,
+					Child: []Node{{
+						Type: Text,
+						Text: []byte("first"),
+					}},
+				}, {
+					Type: Paragraph,
+					Child: []Node{{
+						Type: Text,
+						Text: []byte("second"),
+					}},
+				}},
+			}, {
+				Type: Paragraph,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("story"),
+				}},
+			}, {
+				Type: Note,
+				Child: []Node{{
+					Type: Paragraph,
+					Child: []Node{{
+						Type: Slant,
+						Text: []byte("last"),
+					}},
+				}},
+			}},
+		},
+	},
+	{
+		Desc:  "Author's Note Moved",
+		Input: "story\n\nA/N: note\n\nmore",
+		Output: Node{
+			Type: Group,
+			Child: []Node{{
+				Type: Paragraph,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("story"),
+				}},
+			}, {
+				Type: Paragraph,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("more"),
+				}},
+			}, {
+				Type: Note,
+				Child: []Node{{
+					Type: Paragraph,
+					Child: []Node{{
+						Type: Text,
+						Text: []byte("note"),
+					}},
+				}},
+			}},
+		},
+	},
+	{
+		Desc:  "Quote",
+		Input: "a\n> Dear *John*,\n>\n> > nested\n> -- Mary\nb",
+		Output: Node{
+			Type: Group,
+			Child: []Node{{
+				Type: Paragraph,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("a"),
+				}},
+			}, {
+				Type: Quote,
+				Child: []Node{{
+					Type: Paragraph,
+					Child: []Node{{
+						Type: Text,
+						Text: []byte("Dear "),
+					}, {
+						Type: Bold,
+						Text: []byte("John"),
+					}, {
+						Type: Text,
+						Text: []byte(","),
+					}},
+				}, {
+					Type: Quote,
+					Child: []Node{{
+						Type: Paragraph,
+						Child: []Node{{
+							Type: Text,
+							Text: []byte("nested"),
+						}},
+					}},
+				}, {
+					Type: Attribution,
+					Child: []Node{{
+						Type: Text,
+						Text: []byte("Mary"),
+					}},
+				}},
+			}, {
+				Type: Paragraph,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("b"),
+				}},
+			}},
+		},
+	},
+	{
+		Desc:  "Quote in Preview",
+		Input: "<a\n> b\n>\n> c\n>\nd",
+		Output: Node{
+			Type: Group,
+			Child: []Node{{
+				Type: Preview,
+				Text: []byte("a"),
+				Child: []Node{{
+					Type: Quote,
+					Child: []Node{{
+						Type: Paragraph,
+						Child: []Node{{
+							Type: Text,
+							Text: []byte("b"),
+						}},
+					}, {
+						Type: Paragraph,
+						Child: []Node{{
+							Type: Text,
+							Text: []byte("c"),
+						}},
+					}},
+				}},
+			}, {
+				Type: Paragraph,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("d"),
+				}},
+			}},
+		},
+	},
+	{
+		Desc:  "Line Break",
+		Input: "a\\\nb \\\nc\nd",
+		Output: Node{
+			Type: Group,
+			Child: []Node{{
+				Type: Paragraph,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("a"),
+				}, {
+					Type: LineBreak,
+				}, {
+					Type: Text,
+					Text: []byte("b "),
+				}, {
+					Type: LineBreak,
+				}, {
+					Type: Text,
+					Text: []byte("c d"),
+				}},
+			}},
+		},
+	},
+	{
+		Desc:  "Verse",
+		Input: "a\n| Roses are *red*\n|   violets--blue\n|\n|\tend\nb",
+		Output: Node{
+			Type: Group,
+			Child: []Node{{
+				Type: Paragraph,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("a"),
+				}},
+			}, {
+				Type: Verse,
+				Child: []Node{{
+					Type: Line,
+					Child: []Node{{
+						Type: Text,
+						Text: []byte("Roses are "),
+					}, {
+						Type: Bold,
+						Text: []byte("red"),
+					}},
+				}, {
+					Type: Line,
+					Text: []byte("  "),
+					Child: []Node{{
+						Type: Text,
+						Text: []byte("violets"),
+					}, {
+						Type: NDash,
+					}, {
+						Type: Text,
+						Text: []byte("blue"),
+					}},
+				}, {
+					Type: Line,
+				}, {
+					Type: Line,
+					Text: []byte("    "),
+					Child: []Node{{
+						Type: Text,
+						Text: []byte("end"),
+					}},
+				}},
+			}, {
+				Type: Paragraph,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("b"),
+				}},
+			}},
+		},
+	},
 	{
 		Desc:  "Bad Preview",
 		Input: "<",
@@ -265,7 +660,7 @@ func TestParse(t *testing.T) {
 		if err != nil {
 			t.Fatalf("%s: parse: %s", desc, err)
 		}
-		if !reflect.DeepEqual(out, test.Output) {
+		if !reflect.DeepEqual(withoutPos(out), test.Output) {
 			t.Errorf("%s: Parse tree mismatch:", desc)
 			t.Logf("Got:\n%s", out)
 			t.Logf("Want:\n%s", test.Output)
@@ -273,6 +668,89 @@ func TestParse(t *testing.T) {
 	}
 }
 
+// withoutPos returns a copy of n without positions, so that it can be
+// compared to a tree which was not parsed from the same text.
+func withoutPos(n Node) Node {
+	n, _ = Walk(n, func(c *Cursor) error {
+		if n := c.Node(); n.Pos != 0 {
+			n.Pos = 0
+			c.Replace(n)
+		}
+		return nil
+	}, nil)
+	return n
+}
+
+var positionTests = []struct {
+	Desc  string
+	Input string
+	Pos   map[string]int // The offset of the node with the given text
+}{
+	{
+		Desc:  "Paragraphs",
+		Input: "a *b*\n\n  c---d",
+		Pos:   map[string]int{"a ": 0, "b": 2, "c": 9, "d": 13},
+	},
+	{
+		Desc:  "Front matter",
+		Input: "Title: x\n\na *b*",
+		Pos:   map[string]int{"a ": 10, "b": 12},
+	},
+	{
+		Desc:  "Quote",
+		Input: "> a\n> *b*\n>\n>  c",
+		Pos:   map[string]int{"a": 2, "b": 6, "c": 15},
+	},
+	{
+		Desc:  "Verse and headings",
+		Input: "## /h/ #\n|   a *b*\n| c",
+		Pos:   map[string]int{"h": 3, "  ": 9, "a ": 13, "b": 15, "c": 21},
+	},
+	{
+		Desc:  "Alignment",
+		Input: "->  a <-\n\n->  b *c* ->",
+		Pos:   map[string]int{"a": 4, "b ": 14, "c": 16},
+	},
+	{
+		Desc:  "Unclosed alignment",
+		Input: "->  a a\n\n->*b* c",
+		Pos:   map[string]int{"->  a a": 0, "->": 9, "b": 11, " c": 14},
+	},
+	{
+		Desc:  "Multibyte",
+		Input: "\u00e9t\u00e9 *b*",
+		Pos:   map[string]int{"b": 6},
+	},
+}
+
+func TestPositions(t *testing.T) {
+	for _, test := range positionTests {
+		desc := test.Desc
+
+		fic, _, err := ParseDocumentBytes([]byte(test.Input))
+		if err != nil {
+			t.Fatalf("%s: parse: %s", desc, err)
+		}
+
+		found := map[string]bool{}
+		Inspect(fic, func(n Node) bool {
+			text := string(n.Text)
+			if want, ok := test.Pos[text]; ok {
+				found[text] = true
+				if got := n.Pos; got != want {
+					t.Errorf("%s: %q at %d, want %d", desc, text, got, want)
+				}
+			}
+			return true
+		})
+		for text := range test.Pos {
+			if !found[text] {
+				t.Errorf("%s: %q not found in:\n%s", desc, text, fic)
+			}
+		}
+	}
+}
+
 func BenchmarkParse(b *testing.B) {
 	file, err := os.Open("testdata/lipsum.txt")
 	if err != nil {
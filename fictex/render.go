@@ -1,9 +1,12 @@
 package fictex
 
 import (
+	"bytes"
 	"fmt"
 	"html"
 	"io"
+	"strings"
+	"unicode/utf8"
 )
 
 type StringPair [2]string
@@ -18,13 +21,63 @@ type Renderer struct {
 	Underline StringPair
 	Paragraph StringPair
 
+	// The following bracket the less common inline styles; if UpperSmallCaps
+	// is set, small caps are also written in capital letters
+	Strike         StringPair
+	Super          StringPair
+	SmallCaps      StringPair
+	Spoiler        StringPair
+	UpperSmallCaps bool
+
+	// Heading is formatted with Sprintf(fmt, level, id), where id is the
+	// heading's anchor in the Outline
+	Heading StringPair
+
+	// Bracket centered and right-aligned paragraphs in place of Paragraph;
+	// if AlignWidth is set, their lines are wrapped to that many columns
+	// and padded with spaces to align them
+	Center     StringPair
+	Right      StringPair
+	AlignWidth int
+
 	// The following are used in place of the corresponding node
 	NDash string
 	MDash string
 	HLine string
 
+	// SceneBreak is formatted with Sprintf(fmt, glyph) in place of a scene
+	// break written with one of the Config's SceneBreaks; if it is empty,
+	// HLine is used for every scene break
+	SceneBreak string
+
 	// The first of the pair will be formatted with Sprintf(fmt, preview)
 	Preview StringPair
+
+	// Brackets an author's note
+	Note StringPair
+
+	// Bracket a block quote and its attribution; if QuoteIndent is set, it
+	// is added to the start of every line of the quote
+	Quote       StringPair
+	Attribution StringPair
+	QuoteIndent string
+
+	// Verse brackets a block of Lines; each line's indentation is made of
+	// one VerseIndent for each space.  LineBreak replaces a hard line break.
+	Verse       StringPair
+	Line        StringPair
+	VerseIndent string
+	LineBreak   string
+
+	// The following are formatted with Sprintf(fmt, number); the footnotes
+	// are numbered in the order they are referenced and are rendered
+	// together at the end of the document, bracketed by Footnotes
+	FootnoteRef string
+	Footnote    StringPair
+	Footnotes   StringPair
+
+	// If set, the Typography is applied to the document before rendering
+	Typography *Typography
 }
 
 var TextRenderer = Renderer{
@@ -33,11 +86,39 @@ var TextRenderer = Renderer{
 	Underline: StringPair{"_", "_"},
 	Paragraph: StringPair{"\n    ", "\n"},
 
+	Strike:         StringPair{"~", "~"},
+	Super:          StringPair{"^", "^"},
+	Spoiler:        StringPair{"[Spoiler: ", "]"},
+	UpperSmallCaps: true,
+
+	Heading: StringPair{"\n  ", "\n"},
+
+	Center:     StringPair{"\n", "\n"},
+	Right:      StringPair{"\n", "\n"},
+	AlignWidth: 72,
+
 	NDash: "--",
 	MDash: "---",
 	HLine: "\n-----\n",
 
+	SceneBreak: "\n        %s\n",
+
 	Preview: StringPair{"\n<<%s", ">>\n"},
+
+	Note: StringPair{"\n  A/N:", ""},
+
+	Quote:       StringPair{"", ""},
+	Attribution: StringPair{"\n      -- ", "\n"},
+	QuoteIndent: "    ",
+
+	Verse:       StringPair{"\n", ""},
+	Line:        StringPair{"    ", "\n"},
+	VerseIndent: " ",
+	LineBreak:   "\n    ",
+
+	FootnoteRef: "[%d]",
+	Footnote:    StringPair{"\n    [%d] ", "\n"},
+	Footnotes:   StringPair{"\n-----\n", ""},
 }
 
 var HTMLRenderer = Renderer{
@@ -48,66 +129,351 @@ var HTMLRenderer = Renderer{
 	Underline: StringPair{"<u>", "</u>"},
 	Paragraph: StringPair{"<p>\n", "\n</p>\n"},
 
+	Strike:    StringPair{"<s>", "</s>"},
+	Super:     StringPair{"<sup>", "</sup>"},
+	SmallCaps: StringPair{"<span class=\"smallcaps\">", "</span>"},
+	Spoiler:   StringPair{"<span class=\"spoiler\">", "</span>"},
+
+	Heading: StringPair{"<h%[1]d id=\"%[2]s\">", "</h%[1]d>\n"},
+
+	Center: StringPair{"<p class=\"center\">\n", "\n</p>\n"},
+	Right:  StringPair{"<p class=\"right\">\n", "\n</p>\n"},
+
 	NDash: "&#8211;", //"&ndash;",
 	MDash: "&#8212;", //"&mdash;",
 	HLine: "<hr />\n",
 
+	SceneBreak: "<p class=\"scenebreak\">%s</p>\n",
+
 	Preview: StringPair{"<!-- Fold: %q -->\n", "<!-- /Fold -->\n"},
+
+	Note: StringPair{"<div class=\"note\">\n", "</div>\n"},
+
+	Quote:       StringPair{"<blockquote>\n", "</blockquote>\n"},
+	Attribution: StringPair{"<p class=\"attribution\">\n&#8212; ", "\n</p>\n"},
+
+	Verse:       StringPair{"<p class=\"verse\">\n", "</p>\n"},
+	Line:        StringPair{"", "<br />\n"},
+	VerseIndent: "&#160;",
+	LineBreak:   "<br />\n",
+
+	FootnoteRef: "<sup id=\"fnref-%[1]d\"><a href=\"#fn-%[1]d\">%[1]d</a></sup>",
+	Footnote:    StringPair{"<li id=\"fn-%[1]d\">", " <a href=\"#fnref-%[1]d\">&#8617;</a></li>\n"},
+	Footnotes:   StringPair{"<ol class=\"footnotes\">\n", "</ol>\n"},
+}
+
+var BBCodeRenderer = Renderer{
+	Bold:      StringPair{"[b]", "[/b]"},
+	Slant:     StringPair{"[i]", "[/i]"},
+	Underline: StringPair{"[u]", "[/u]"},
+	Paragraph: StringPair{"", "\n\n"},
+
+	Strike:         StringPair{"[s]", "[/s]"},
+	Super:          StringPair{"[sup]", "[/sup]"},
+	Spoiler:        StringPair{"[spoiler]", "[/spoiler]"},
+	UpperSmallCaps: true,
+
+	Heading: StringPair{"[b]", "[/b]\n\n"},
+
+	Center: StringPair{"[center]", "[/center]\n\n"},
+	Right:  StringPair{"[right]", "[/right]\n\n"},
+
+	NDash: "\u2013",
+	MDash: "\u2014",
+	HLine: "[hr]\n",
+
+	SceneBreak: "[center]%s[/center]\n",
+
+	Preview: StringPair{"[b]%s[/b]\n\n", ""},
+
+	Note: StringPair{"[i]", "[/i]\n"},
+
+	Quote:       StringPair{"[quote]\n", "[/quote]\n"},
+	Attribution: StringPair{"\u2014 ", "\n"},
+
+	Verse:       StringPair{"", "\n"},
+	Line:        StringPair{"", "\n"},
+	VerseIndent: " ",
+	LineBreak:   "\n",
+
+	FootnoteRef: "[sup]%d[/sup]",
+	Footnote:    StringPair{"[sup]%d[/sup] ", "\n"},
+	Footnotes:   StringPair{"[hr]\n", ""},
 }
 
 func (r Renderer) Render(w io.Writer, n Node) error {
+	return r.render(w, n, newRenderState())
+}
+
+// A renderState is what is kept from one node to the next while rendering
+// a document, so that a document rendered in pieces is numbered as a whole.
+type renderState struct {
+	numbers map[string]int // The number of each footnote label
+	ids     anchors
+}
+
+func newRenderState() *renderState {
+	return &renderState{numbers: map[string]int{}, ids: anchors{}}
+}
+
+// render renders a node, numbering footnotes and headings after those
+// already rendered with the same state.
+func (r Renderer) render(w io.Writer, n Node, st *renderState) error {
+	if r.Typography != nil {
+		n = r.Typography.Apply(n)
+	}
+
 	esc := func(b []byte) string {
 		if r.Escape == nil {
 			return string(b)
 		}
 		return r.Escape(string(b))
 	}
+	write := func(s string) error {
+		_, err := io.WriteString(w, s)
+		return err
+	}
+	style := func(pair StringPair, text []byte) error {
+		return write(pair[0] + esc(text) + pair[1])
+	}
+
+	// Some nodes are rendered on their own so that their text can be
+	// indented or aligned; the writers they replaced are kept here
+	var stack []io.Writer
+	buffer := func() {
+		stack = append(stack, w)
+		w = new(bytes.Buffer)
+	}
+	unbuffer := func() string {
+		b := w.(*bytes.Buffer)
+		w, stack = stack[len(stack)-1], stack[:len(stack)-1]
+		return b.String()
+	}
+
+	paragraph := func(n Node) StringPair {
+		switch {
+		case n.Align == Center && r.Center != (StringPair{}):
+			return r.Center
+		case n.Align == Right && r.Right != (StringPair{}):
+			return r.Right
+		}
+		return r.Paragraph
+	}
+	aligned := func(n Node) bool {
+		return n.Align != Normal && r.AlignWidth > 0
+	}
+
+	// Footnotes are numbered as they are referenced
+	numbers := st.numbers
+	number := func(label string) int {
+		if _, ok := numbers[label]; !ok {
+			numbers[label] = len(numbers) + 1
+		}
+		return numbers[label]
+	}
+	var footnotes []Node
 
-	var render func(Node) error
-	render = func(n Node) (err error) {
-		switch n.Type {
+	ids := st.ids
+	var heading string // The id of the current heading
+
+	enter := func(c *Cursor) error {
+		switch n := c.Node(); n.Type {
 		case Group:
-			for _, n := range n.Child {
-				if err := render(n); err != nil {
-					return err
-				}
-			}
 		case Text:
-			_, err = io.WriteString(w, esc(n.Text))
+			return write(esc(n.Text))
 		case Bold:
-			_, err = fmt.Fprintf(w, "%s%s%s", r.Bold[0], esc(n.Text), r.Bold[1])
+			return style(r.Bold, n.Text)
 		case Slant:
-			_, err = fmt.Fprintf(w, "%s%s%s", r.Slant[0], esc(n.Text), r.Slant[1])
+			return style(r.Slant, n.Text)
 		case Underline:
-			_, err = fmt.Fprintf(w, "%s%s%s", r.Underline[0], esc(n.Text), r.Underline[1])
+			return style(r.Underline, n.Text)
+		case Strike:
+			return style(r.Strike, n.Text)
+		case Super:
+			return style(r.Super, n.Text)
+		case SmallCaps:
+			if r.UpperSmallCaps {
+				return style(r.SmallCaps, bytes.ToUpper(n.Text))
+			}
+			return style(r.SmallCaps, n.Text)
+		case Spoiler:
+			return style(r.Spoiler, n.Text)
 		case Paragraph:
-			if _, err := io.WriteString(w, r.Paragraph[0]); err != nil {
+			if err := write(paragraph(n)[0]); err != nil {
 				return err
 			}
-			for _, n := range n.Child {
-				render(n)
+			if aligned(n) {
+				buffer()
 			}
-			_, err = io.WriteString(w, r.Paragraph[1])
+		case Heading:
+			heading = ids.id(TextContent(n))
+			return write(sprintf(r.Heading[0], n.Level, heading))
 		case NDash:
-			_, err = io.WriteString(w, r.NDash)
+			return write(r.NDash)
 		case MDash:
-			_, err = io.WriteString(w, r.MDash)
+			return write(r.MDash)
 		case HLine:
-			_, err = io.WriteString(w, r.HLine)
+			if len(n.Text) > 0 && r.SceneBreak != "" {
+				return write(fmt.Sprintf(r.SceneBreak, esc(n.Text)))
+			}
+			return write(r.HLine)
 		case Preview:
-			if _, err := fmt.Fprintf(w, r.Preview[0], esc(n.Text)); err != nil {
+			return write(fmt.Sprintf(r.Preview[0], esc(n.Text)))
+		case Note:
+			return write(r.Note[0])
+		case Quote:
+			if err := write(r.Quote[0]); err != nil {
+				return err
+			}
+			if r.QuoteIndent != "" {
+				buffer()
+			}
+		case Attribution:
+			return write(r.Attribution[0])
+		case Verse:
+			return write(r.Verse[0])
+		case Line:
+			return write(r.Line[0] + strings.Repeat(r.VerseIndent, len(n.Text)))
+		case LineBreak:
+			return write(r.LineBreak)
+		case FootnoteRef:
+			return write(sprintf(r.FootnoteRef, number(string(n.Text))))
+		case Footnote:
+			footnotes = append(footnotes, n) // rendered at the end
+			return SkipChildren
+		default:
+			if _, err := fmt.Fprintf(w, "Unhandled %T\n", n); err != nil {
 				return err
 			}
-			for _, n := range n.Child {
-				if err := render(n); err != nil {
+			return SkipChildren
+		}
+		return nil
+	}
+
+	leave := func(c *Cursor) error {
+		switch n := c.Node(); n.Type {
+		case Paragraph:
+			if aligned(n) {
+				if err := write(align(unbuffer(), n.Align, r.AlignWidth)); err != nil {
 					return err
 				}
 			}
-			_, err = io.WriteString(w, r.Preview[1])
-		default:
-			_, err = fmt.Fprintf(w, "Unhandled %T\n", n)
+			return write(paragraph(n)[1])
+		case Heading:
+			return write(sprintf(r.Heading[1], n.Level, heading))
+		case Preview:
+			return write(r.Preview[1])
+		case Note:
+			return write(r.Note[1])
+		case Quote:
+			if r.QuoteIndent != "" {
+				if err := write(indent(unbuffer(), r.QuoteIndent)); err != nil {
+					return err
+				}
+			}
+			return write(r.Quote[1])
+		case Attribution:
+			return write(r.Attribution[1])
+		case Verse:
+			return write(r.Verse[1])
+		case Line:
+			return write(r.Line[1])
+		}
+		return nil
+	}
+
+	if _, err := Walk(n, enter, leave); err != nil {
+		return err
+	}
+	if len(footnotes) == 0 {
+		return nil
+	}
+
+	// Render the footnotes in the order they were referenced, followed by
+	// any which were never referenced
+	referenced := make([]Node, len(numbers))
+	var sorted []Node
+	for _, n := range footnotes {
+		if num, ok := numbers[string(n.Text)]; ok {
+			referenced[num-1] = n
+		} else {
+			sorted = append(sorted, n)
 		}
+	}
+	sorted = append(referenced, sorted...)
+
+	if err := write(r.Footnotes[0]); err != nil {
 		return err
 	}
-	return render(n)
+	for _, n := range sorted {
+		if n.Type != Footnote {
+			continue // referenced but never defined
+		}
+		num := number(string(n.Text))
+		if err := write(sprintf(r.Footnote[0], num)); err != nil {
+			return err
+		}
+		if _, err := Walk(Node{Type: Group, Child: n.Child}, enter, leave); err != nil {
+			return err
+		}
+		if err := write(sprintf(r.Footnote[1], num)); err != nil {
+			return err
+		}
+	}
+	return write(r.Footnotes[1])
+}
+
+// indent adds prefix to the start of every non-empty line of s.
+func indent(s, prefix string) string {
+	lines := strings.SplitAfter(s, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// align wraps each line of s to width columns and pads it with spaces to
+// center or right-align it.  Empty lines are dropped.
+func align(s string, a Alignment, width int) string {
+	var out []string
+	pad := func(line string) {
+		n := width - utf8.RuneCountInString(line)
+		if a == Center {
+			n /= 2
+		}
+		if n < 0 {
+			n = 0
+		}
+		out = append(out, strings.Repeat(" ", n)+line)
+	}
+
+	for _, line := range strings.Split(s, "\n") {
+		cur := ""
+		for _, word := range strings.Fields(line) {
+			switch {
+			case cur == "":
+				cur = word
+			case utf8.RuneCountInString(cur)+1+utf8.RuneCountInString(word) > width:
+				pad(cur)
+				cur = word
+			default:
+				cur += " " + word
+			}
+		}
+		if cur != "" {
+			pad(cur)
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// sprintf formats a footnote number or heading, allowing for formats which
+// do not use them.
+func sprintf(format string, args ...interface{}) string {
+	if !strings.Contains(format, "%") {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
 }
@@ -4,14 +4,16 @@ import (
 	"bytes"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 )
 
 var renderTests = []struct{
-	Desc  string
-	Input Node
-	Text  string
-	HTML  string
+	Desc   string
+	Input  Node
+	Text   string
+	HTML   string
+	BBCode string // Only checked if set
 }{
 	{
 		Desc: "Basic test",
@@ -64,6 +66,81 @@ var renderTests = []struct{
 		Text: "\n    *a*/b/_c_\n",
 		HTML: "<p>\n<b>a</b><i>b</i><u>c</u>\n</p>\n",
 	},
+	{
+		Desc: "Inline Styles",
+		Input: Node{
+			Type: Paragraph,
+			Child: []Node{{
+				Type: Strike,
+				Text: []byte("a"),
+			}, {
+				Type: Super,
+				Text: []byte("b"),
+			}, {
+				Type: SmallCaps,
+				Text: []byte("Exit"),
+			}, {
+				Type: Spoiler,
+				Text: []byte("d"),
+			}},
+		},
+		Text:   "\n    ~a~^b^EXIT[Spoiler: d]\n",
+		HTML:   "<p>\n<s>a</s><sup>b</sup><span class=\"smallcaps\">Exit</span><span class=\"spoiler\">d</span>\n</p>\n",
+		BBCode: "[s]a[/s][sup]b[/sup]EXIT[spoiler]d[/spoiler]\n\n",
+	},
+	{
+		Desc: "Alignment",
+		Input: Node{
+			Type: Group,
+			Child: []Node{{
+				Type:  Paragraph,
+				Align: Center,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("Title"),
+				}},
+			}, {
+				Type:  Paragraph,
+				Align: Right,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("Me"),
+				}},
+			}},
+		},
+		Text:   "\n" + strings.Repeat(" ", 33) + "Title\n\n" + strings.Repeat(" ", 70) + "Me\n",
+		HTML:   "<p class=\"center\">\nTitle\n</p>\n<p class=\"right\">\nMe\n</p>\n",
+		BBCode: "[center]Title[/center]\n\n[right]Me[/right]\n\n",
+	},
+	{
+		Desc: "Headings",
+		Input: Node{
+			Type: Group,
+			Child: []Node{{
+				Type:  Heading,
+				Level: 2,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("Part One: Winter"),
+				}},
+			}, {
+				Type:  Heading,
+				Level: 3,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("Part One"),
+				}, {
+					Type: MDash,
+				}, {
+					Type: Text,
+					Text: []byte("Winter"),
+				}},
+			}},
+		},
+		Text:   "\n  Part One: Winter\n\n  Part One---Winter\n",
+		HTML:   "<h2 id=\"part-one-winter\">Part One: Winter</h2>\n<h3 id=\"part-one-winter-2\">Part One&#8212;Winter</h3>\n",
+		BBCode: "[b]Part One: Winter[/b]\n\n[b]Part One\u2014Winter[/b]\n\n",
+	},
 	{
 		Desc: "Dashes",
 		Input: Node{
@@ -82,6 +159,19 @@ var renderTests = []struct{
 		Text: "-- ---\n-----\n",
 		HTML: "&#8211; &#8212;<hr />\n",
 	},
+	{
+		Desc: "Scene Break",
+		Input: Node{
+			Type: Group,
+			Child: []Node{{
+				Type: HLine,
+				Text: []byte("<~>"),
+			}},
+		},
+		Text:   "\n        <~>\n",
+		HTML:   "<p class=\"scenebreak\">&lt;~&gt;</p>\n",
+		BBCode: "[center]<~>[/center]\n",
+	},
 	{
 		Desc: "Preview",
 		Input: Node{
@@ -98,6 +188,132 @@ var renderTests = []struct{
 		Text: "\n<<short\n    long\n>>\n",
 		HTML: "<!-- Fold: \"short\" -->\n<p>\nlong\n</p>\n<!-- /Fold -->\n",
 	},
+	{
+		Desc: "Footnotes",
+		Input: Node{
+			Type: Group,
+			Child: []Node{{
+				Type: Footnote,
+				Text: []byte("b"),
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("B"),
+				}},
+			}, {
+				Type: Paragraph,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("x"),
+				}, {
+					Type: FootnoteRef,
+					Text: []byte("a"),
+				}, {
+					Type: FootnoteRef,
+					Text: []byte("b"),
+				}},
+			}, {
+				Type: Footnote,
+				Text: []byte("a"),
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("A"),
+				}},
+			}, {
+				Type: Footnote,
+				Text: []byte("c"),
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("C"),
+				}},
+			}},
+		},
+		Text: "\n    x[1][2]\n\n-----\n\n    [1] A\n\n    [2] B\n\n    [3] C\n",
+		HTML: "<p>\nx" +
+			"<sup id=\"fnref-1\"><a href=\"#fn-1\">1</a></sup>" +
+			"<sup id=\"fnref-2\"><a href=\"#fn-2\">2</a></sup>\n</p>\n" +
+			"<ol class=\"footnotes\">\n" +
+			"<li id=\"fn-1\">A <a href=\"#fnref-1\">&#8617;</a></li>\n" +
+			"<li id=\"fn-2\">B <a href=\"#fnref-2\">&#8617;</a></li>\n" +
+			"<li id=\"fn-3\">C <a href=\"#fnref-3\">&#8617;</a></li>\n" +
+			"</ol>\n",
+	},
+	{
+		Desc: "Note",
+		Input: Node{
+			Type: Note,
+			Child: []Node{{
+				Type: Paragraph,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("thanks"),
+				}},
+			}},
+		},
+		Text: "\n  A/N:\n    thanks\n",
+		HTML: "<div class=\"note\">\n<p>\nthanks\n</p>\n</div>\n",
+	},
+	{
+		Desc: "Quote",
+		Input: Node{
+			Type: Quote,
+			Child: []Node{{
+				Type: Paragraph,
+				Child: []Node{{
+					Type: Slant,
+					Text: []byte("hi"),
+				}},
+			}, {
+				Type: Attribution,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("me"),
+				}},
+			}},
+		},
+		Text:   "\n        /hi/\n\n          -- me\n",
+		HTML:   "<blockquote>\n<p>\n<i>hi</i>\n</p>\n<p class=\"attribution\">\n&#8212; me\n</p>\n</blockquote>\n",
+		BBCode: "[quote]\n[i]hi[/i]\n\n\u2014 me\n[/quote]\n",
+	},
+	{
+		Desc: "Verse",
+		Input: Node{
+			Type: Verse,
+			Child: []Node{{
+				Type: Line,
+				Child: []Node{{
+					Type: Text,
+					Text: []byte("a"),
+				}},
+			}, {
+				Type: Line,
+				Text: []byte("  "),
+				Child: []Node{{
+					Type: Slant,
+					Text: []byte("b"),
+				}},
+			}},
+		},
+		Text:   "\n    a\n      /b/\n",
+		HTML:   "<p class=\"verse\">\na<br />\n&#160;&#160;<i>b</i><br />\n</p>\n",
+		BBCode: "a\n  [i]b[/i]\n\n",
+	},
+	{
+		Desc: "Line Break",
+		Input: Node{
+			Type: Paragraph,
+			Child: []Node{{
+				Type: Text,
+				Text: []byte("a"),
+			}, {
+				Type: LineBreak,
+			}, {
+				Type: Text,
+				Text: []byte("b"),
+			}},
+		},
+		Text: "\n    a\n    b\n",
+		HTML: "<p>\na<br />\nb\n</p>\n",
+	},
 }
 
 func TestRender(t *testing.T) {
@@ -119,6 +335,17 @@ func TestRender(t *testing.T) {
 		if got, want := b.String(), test.HTML; got != want {
 			t.Errorf("%s: renderhtml = %q, want %q", desc, got, want)
 		}
+
+		if test.BBCode == "" {
+			continue
+		}
+		b.Truncate(0)
+		if err := BBCodeRenderer.Render(b, test.Input); err != nil {
+			t.Fatalf("%s: renderbbcode: %s", desc, err)
+		}
+		if got, want := b.String(), test.BBCode; got != want {
+			t.Errorf("%s: renderbbcode = %q, want %q", desc, got, want)
+		}
 	}
 }
 
@@ -0,0 +1,317 @@
+package spell
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A Dictionary is a list of words and the affixes which may be added to
+// them, read from a Hunspell .aff and .dic file.  Only the parts of the
+// format needed to check and suggest single words are supported: prefixes,
+// suffixes, TRY, REP, NOSUGGEST, and the FLAG formats.  Compounds and
+// morphology are ignored.
+type Dictionary struct {
+	words     map[string]string // Each stem and its flags
+	prefixes  []affix
+	suffixes  []affix
+	try       string
+	rep       [][2]string
+	flags     string // The FLAG format: "", "long", "num", or "UTF-8"
+	nosuggest string // The flag of words which are never suggested
+}
+
+// An affix is a rule for adding a prefix or suffix to a stem with a flag.
+type affix struct {
+	flag  string
+	cross bool   // Whether the affix combines with affixes at the other end
+	strip string // Removed from the stem before adding
+	add   string
+	cond  []class // What the stem must start or end with
+}
+
+// A class is one character of an affix condition: a set of runes, or any
+// rune not in the set if not is true.  "." is an empty negated set.
+type class struct {
+	runes string
+	not   bool
+}
+
+// A FormatError describes a line of a dictionary which could not be read.
+type FormatError struct {
+	File string // "aff" or "dic"
+	Line int
+	Msg  string
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("spell: %s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+// Load reads a dictionary from its .aff and .dic files, which must be
+// encoded in UTF-8.
+func Load(aff, dic io.Reader) (*Dictionary, error) {
+	d := &Dictionary{words: map[string]string{}}
+	if err := d.readAffixes(aff); err != nil {
+		return nil, err
+	}
+	if err := d.readWords(dic); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Dictionary) readAffixes(r io.Reader) error {
+	cross := map[string]bool{} // From the header of each affix flag
+	s := bufio.NewScanner(r)
+	line := 0
+	fail := func(format string, args ...interface{}) error {
+		return &FormatError{"aff", line, fmt.Sprintf(format, args...)}
+	}
+	for s.Scan() {
+		line++
+		f := strings.Fields(s.Text())
+		if len(f) == 0 || strings.HasPrefix(f[0], "#") {
+			continue
+		}
+		switch f[0] {
+		case "SET":
+			if len(f) < 2 || !strings.EqualFold(f[1], "UTF-8") {
+				return fail("unsupported encoding %s", strings.Join(f[1:], " "))
+			}
+		case "FLAG":
+			if len(f) < 2 {
+				return fail("missing FLAG format")
+			}
+			switch f[1] {
+			case "long", "num", "UTF-8":
+				d.flags = f[1]
+			default:
+				return fail("unknown FLAG format %s", f[1])
+			}
+		case "TRY":
+			if len(f) > 1 {
+				d.try = f[1]
+			}
+		case "NOSUGGEST":
+			if len(f) > 1 {
+				d.nosuggest = f[1]
+			}
+		case "REP":
+			// The first REP line only gives the number of replacements
+			if len(f) >= 3 {
+				from := strings.Replace(f[1], "_", " ", -1)
+				to := strings.Replace(f[2], "_", " ", -1)
+				d.rep = append(d.rep, [2]string{from, to})
+			}
+		case "PFX", "SFX":
+			if len(f) < 4 {
+				return fail("short %s line", f[0])
+			}
+			key := f[0] + " " + f[1]
+			if _, seen := cross[key]; !seen {
+				if f[2] != "Y" && f[2] != "N" {
+					return fail("%s %s has no header", f[0], f[1])
+				}
+				cross[key] = f[2] == "Y"
+				continue
+			}
+			a := affix{flag: f[1], cross: cross[key], strip: f[2], add: f[3]}
+			if a.strip == "0" {
+				a.strip = ""
+			}
+			// Flags on the affix itself are for compounds and further affixes
+			if i := strings.Index(a.add, "/"); i >= 0 {
+				a.add = a.add[:i]
+			}
+			if a.add == "0" {
+				a.add = ""
+			}
+			cond := "."
+			if len(f) > 4 {
+				cond = f[4]
+			}
+			var err error
+			if a.cond, err = parseCondition(cond); err != nil {
+				return fail("%s", err)
+			}
+			if f[0] == "PFX" {
+				d.prefixes = append(d.prefixes, a)
+			} else {
+				d.suffixes = append(d.suffixes, a)
+			}
+		}
+	}
+	return s.Err()
+}
+
+// parseCondition parses an affix condition such as "[^aeiou]y".
+func parseCondition(cond string) ([]class, error) {
+	if cond == "." {
+		return nil, nil
+	}
+	var out []class
+	for cond != "" {
+		switch cond[0] {
+		case '.':
+			out = append(out, class{not: true})
+			cond = cond[1:]
+		case '[':
+			end := strings.Index(cond, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed [ in condition %q", cond)
+			}
+			c := class{runes: cond[1:end]}
+			if strings.HasPrefix(c.runes, "^") {
+				c.runes, c.not = c.runes[1:], true
+			}
+			out = append(out, c)
+			cond = cond[end+1:]
+		default:
+			_, size := utf8.DecodeRuneInString(cond)
+			out = append(out, class{runes: cond[:size]})
+			cond = cond[size:]
+		}
+	}
+	return out, nil
+}
+
+func (d *Dictionary) readWords(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	line := 0
+	for s.Scan() {
+		line++
+		text := strings.TrimSpace(s.Text())
+		// The first line is the number of words
+		if line == 1 {
+			if _, err := strconv.Atoi(text); err == nil {
+				continue
+			}
+		}
+		// Anything after a space or tab is morphological data
+		if i := strings.IndexAny(text, " \t"); i >= 0 {
+			text = text[:i]
+		}
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		word, flags := text, ""
+		if i := strings.Index(text, "/"); i >= 0 {
+			word, flags = text[:i], text[i+1:]
+		}
+		d.words[word] += flags
+	}
+	return s.Err()
+}
+
+// hasFlag reports whether a list of flags includes a flag.
+func (d *Dictionary) hasFlag(flags, flag string) bool {
+	switch d.flags {
+	case "long":
+		for i := 0; i+1 < len(flags); i += 2 {
+			if flags[i:i+2] == flag {
+				return true
+			}
+		}
+		return false
+	case "num":
+		for _, f := range strings.Split(flags, ",") {
+			if f == flag {
+				return true
+			}
+		}
+		return false
+	}
+	return strings.Contains(flags, flag)
+}
+
+// matches reports whether the end of a stem, or its start for a prefix,
+// meets the affix's condition.
+func (a affix) matches(stem string, prefix bool) bool {
+	if utf8.RuneCountInString(stem) < len(a.cond) {
+		return false
+	}
+	runes := []rune(stem)
+	if !prefix {
+		runes = runes[len(runes)-len(a.cond):]
+	}
+	for i, c := range a.cond {
+		if strings.ContainsRune(c.runes, runes[i]) == c.not {
+			return false
+		}
+	}
+	return true
+}
+
+// known reports whether a word is in the dictionary exactly as written,
+// either as a stem or a stem with affixes.
+func (d *Dictionary) known(word string) bool {
+	_, ok := d.lookup(word)
+	return ok
+}
+
+// suggestable reports whether a known word may be suggested: whether it
+// has no stem with the NOSUGGEST flag, such as a vulgar word.
+func (d *Dictionary) suggestable(word string) bool {
+	flags, ok := d.lookup(word)
+	return ok && (d.nosuggest == "" || !d.hasFlag(flags, d.nosuggest))
+}
+
+// lookup returns the flags of the stem a word is formed from, and whether
+// the word is known.
+func (d *Dictionary) lookup(word string) (string, bool) {
+	if flags, ok := d.words[word]; ok {
+		return flags, true
+	}
+	for _, p := range d.prefixes {
+		if stem, ok := p.remove(word, true); ok && d.stemHas(stem, p.flag) {
+			return d.words[stem], true
+		}
+	}
+	for _, s := range d.suffixes {
+		stem, ok := s.remove(word, false)
+		if !ok {
+			continue
+		}
+		if d.stemHas(stem, s.flag) {
+			return d.words[stem], true
+		}
+		if !s.cross {
+			continue
+		}
+		for _, p := range d.prefixes {
+			if !p.cross {
+				continue
+			}
+			if root, ok := p.remove(stem, true); ok && d.stemHas(root, p.flag) && d.stemHas(root, s.flag) {
+				return d.words[root], true
+			}
+		}
+	}
+	return "", false
+}
+
+// remove undoes an affix, returning the stem it was added to.
+func (a affix) remove(word string, prefix bool) (string, bool) {
+	var stem string
+	if prefix {
+		if !strings.HasPrefix(word, a.add) || len(word) == len(a.add) {
+			return "", false
+		}
+		stem = a.strip + word[len(a.add):]
+	} else {
+		if !strings.HasSuffix(word, a.add) || len(word) == len(a.add) {
+			return "", false
+		}
+		stem = word[:len(word)-len(a.add)] + a.strip
+	}
+	return stem, a.matches(stem, prefix)
+}
+
+func (d *Dictionary) stemHas(stem, flag string) bool {
+	flags, ok := d.words[stem]
+	return ok && d.hasFlag(flags, flag)
+}
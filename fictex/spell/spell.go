@@ -0,0 +1,248 @@
+// Package spell checks the spelling of a fictex document against a
+// Hunspell dictionary and lists of extra words, such as the names of
+// characters and places.
+package spell
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"fictex"
+	"fictex/lint"
+)
+
+// MaxSuggestions is the most suggestions returned for a word.
+const MaxSuggestions = 5
+
+// A Misspelling is a word which was not found.  Pos and End are its byte
+// offsets in the source of the document; 0 <= Pos <= End <= len(src) even
+// if the nodes had the wrong positions.
+type Misspelling struct {
+	Word        string
+	Pos, End    int
+	Suggestions []string
+}
+
+// A Checker checks words against a dictionary and a list of extra words.
+// Extra words are taken as written: a capitalized word must be capitalized
+// to be correct, and a lowercase word may be capitalized.
+type Checker struct {
+	dict  *Dictionary // May be nil to check only the extra words
+	extra map[string]bool
+}
+
+// NewChecker returns a checker which accepts the words in the dictionary
+// and the extra words.  The dictionary is not modified.
+func NewChecker(dict *Dictionary, extra ...string) *Checker {
+	c := &Checker{dict: dict, extra: map[string]bool{}}
+	for _, w := range extra {
+		if w = strings.TrimSpace(w); w != "" {
+			c.extra[normalize(w)] = true
+		}
+	}
+	return c
+}
+
+// Correct reports whether a word is spelled correctly.  Words with digits
+// are always correct, and a possessive is correct if the word it is formed
+// from is.
+func (c *Checker) Correct(word string) bool {
+	word = normalize(word)
+	if strings.IndexFunc(word, unicode.IsDigit) >= 0 {
+		return true
+	}
+	if c.correct(word) {
+		return true
+	}
+	if stem := strings.TrimSuffix(word, "'s"); stem != word && stem != "" {
+		return c.correct(stem)
+	}
+	return false
+}
+
+// correct reports whether a word, or the forms it may take when written
+// with more capitals, is known.
+func (c *Checker) correct(word string) bool {
+	for _, w := range forms(word) {
+		if c.extra[w] || c.dict != nil && c.dict.known(w) {
+			return true
+		}
+	}
+	return false
+}
+
+// forms returns the ways a word may be written in a dictionary.  A
+// capitalized word may be a lowercase word at the start of a sentence, and
+// a word in capitals may be either.
+func forms(word string) []string {
+	out := []string{word}
+	lower := strings.ToLower(word)
+	if lower == word {
+		return out
+	}
+	if t := title(lower); word == strings.ToUpper(word) && t != word {
+		out = append(out, t)
+	}
+	return append(out, lower)
+}
+
+// normalize writes typographic apostrophes as straight ones, as they are
+// in dictionaries.
+func normalize(word string) string {
+	return strings.Replace(word, "’", "'", -1)
+}
+
+// Check returns the misspelled words of a document parsed from src, each
+// with suggestions.  Markup, URLs, and email addresses are skipped.
+func (c *Checker) Check(n fictex.Node, src []byte) []Misspelling {
+	var out []Misspelling
+	suggestions := map[string][]string{}
+	for _, w := range lint.Words(n, src) {
+		if c.Correct(w.Text) {
+			continue
+		}
+		s, ok := suggestions[w.Text]
+		if !ok {
+			s = c.Suggest(w.Text)
+			suggestions[w.Text] = s
+		}
+		out = append(out, Misspelling{w.Text, w.Pos, w.End, s})
+	}
+	return out
+}
+
+// Suggest returns up to MaxSuggestions correct words which are one edit
+// from a word, or which the dictionary's REP table says are often meant
+// instead of it.  Suggestions are capitalized like the word.
+func (c *Checker) Suggest(word string) []string {
+	word = normalize(word)
+	lower := strings.ToLower(word)
+
+	try, rep := "abcdefghijklmnopqrstuvwxyz'", [][2]string(nil)
+	if c.dict != nil {
+		if c.dict.try != "" {
+			try = c.dict.try
+		}
+		rep = c.dict.rep
+	}
+
+	var out []string
+	seen := map[string]bool{}
+	add := func(s string) {
+		if seen[s] || len(out) >= MaxSuggestions {
+			return
+		}
+		seen[s] = true
+		// Names are only correct when capitalized
+		for _, s := range []string{s, title(s)} {
+			if s != lower && c.correctWords(s) {
+				out = append(out, s)
+				return
+			}
+		}
+	}
+
+	for _, r := range rep {
+		for i := 0; ; {
+			j := strings.Index(lower[i:], r[0])
+			if j < 0 {
+				break
+			}
+			i += j
+			add(lower[:i] + r[1] + lower[i+len(r[0]):])
+			i++
+		}
+	}
+	for _, e := range edits(lower, try) {
+		add(e)
+	}
+
+	for i, s := range out {
+		out[i] = capitalize(s, word)
+	}
+	return out
+}
+
+// edits returns the words one edit from a lowercase word: two words of at
+// least two runes split by a space, a rune removed, two runes swapped, a rune replaced, or a rune
+// inserted.  Replaced and inserted runes are taken from try, which lists
+// the most likely first.
+func edits(word, try string) []string {
+	runes := []rune(word)
+	var out []string
+	for i := 2; i+1 < len(runes); i++ {
+		out = append(out, string(runes[:i])+" "+string(runes[i:]))
+	}
+	for i := range runes {
+		out = append(out, string(runes[:i])+string(runes[i+1:]))
+	}
+	for i := 0; i+1 < len(runes); i++ {
+		if runes[i] != runes[i+1] {
+			swapped := append([]rune{}, runes...)
+			swapped[i], swapped[i+1] = swapped[i+1], swapped[i]
+			out = append(out, string(swapped))
+		}
+	}
+	for _, t := range try {
+		t := unicode.ToLower(t)
+		for i := range runes {
+			if runes[i] != t {
+				out = append(out, string(runes[:i])+string(t)+string(runes[i+1:]))
+			}
+		}
+		for i := 0; i <= len(runes); i++ {
+			out = append(out, string(runes[:i])+string(t)+string(runes[i:]))
+		}
+	}
+	return out
+}
+
+// correctWords reports whether each of the words separated by spaces is
+// correct and may be suggested.
+func (c *Checker) correctWords(s string) bool {
+	for _, w := range strings.Fields(s) {
+		if !c.Correct(w) || !c.suggestable(w) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// suggestable reports whether a correct word may be suggested: whether it
+// is an extra word or is not marked NOSUGGEST in the dictionary.
+func (c *Checker) suggestable(word string) bool {
+	for _, w := range forms(normalize(word)) {
+		if c.extra[w] {
+			return true
+		}
+		if c.dict != nil && c.dict.known(w) {
+			return c.dict.suggestable(w)
+		}
+	}
+	return true
+}
+
+// title returns s with its first rune in upper case.
+func title(s string) string {
+	if s == "" {
+		return s
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[size:]
+}
+
+// capitalize writes a suggestion with the capitals of the word it is for.
+// A suggestion which has its own capitals, like a name, is left as it is.
+func capitalize(s, word string) string {
+	if s != strings.ToLower(s) {
+		return s
+	}
+	switch {
+	case word == strings.ToUpper(word) && utf8.RuneCountInString(word) > 1:
+		return strings.ToUpper(s)
+	case word != strings.ToLower(word):
+		return title(s)
+	}
+	return s
+}
@@ -0,0 +1,178 @@
+package spell
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"fictex"
+)
+
+const testAff = `
+SET UTF-8
+TRY eaiotnrsl
+REP 1
+REP f ph
+NOSUGGEST !
+
+SFX S Y 2
+SFX S y ies [^aeiou]y
+SFX S 0 s [^y]
+
+SFX D Y 2
+SFX D 0 d e
+SFX D 0 ed [^e]
+
+SFX M Y 1
+SFX M 0 's .
+
+PFX U Y 1
+PFX U 0 un .
+`
+
+const testDic = `9
+cat/SM
+hat
+hit/!
+story/S
+hope/DU
+lock/DU
+graph/S
+the
+London
+`
+
+func testDictionary(t *testing.T) *Dictionary {
+	d, err := Load(strings.NewReader(testAff), strings.NewReader(testDic))
+	if err != nil {
+		t.Fatalf("load: %s", err)
+	}
+	return d
+}
+
+var correctTests = []struct {
+	Word    string
+	Correct bool
+}{
+	{"cat", true},
+	{"cats", true},
+	{"cat's", true},
+	{"dog's", false},
+	{"stories", true},
+	{"storys", false},
+	{"hoped", true},
+	{"unhoped", true},
+	{"unlocked", true},
+	{"locks", false},
+	{"Cat", true},
+	{"CATS", true},
+	{"hit", true},
+	{"london", false},
+	{"London", true},
+	{"LONDON", true},
+	{"London’s", true},
+	{"Mira", true},
+	{"mira", false},
+	{"ash", true},
+	{"Ash", true},
+	{"1920s", true},
+	{"dog", false},
+}
+
+func TestCorrect(t *testing.T) {
+	c := NewChecker(testDictionary(t), "Mira", "ash", " ")
+	for _, test := range correctTests {
+		if got := c.Correct(test.Word); got != test.Correct {
+			t.Errorf("Correct(%q) = %v, want %v", test.Word, got, test.Correct)
+		}
+	}
+}
+
+var suggestTests = []struct {
+	Word        string
+	Suggestions []string
+}{
+	{"cta", []string{"cat"}},
+	{"Cta", []string{"Cat"}},
+	{"CTA", []string{"CAT"}},
+	{"grafs", []string{"graphs"}},
+	{"londn", []string{"London"}},
+	{"thecat", []string{"the cat"}},
+	{"hxt", []string{"hat"}},
+	{"zzzz", nil},
+}
+
+func TestSuggest(t *testing.T) {
+	c := NewChecker(testDictionary(t))
+	for _, test := range suggestTests {
+		if got := c.Suggest(test.Word); !reflect.DeepEqual(got, test.Suggestions) {
+			t.Errorf("Suggest(%q) = %q, want %q", test.Word, got, test.Suggestions)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	input := "The *cta* sat\non http://example.com/dog by Mira's cta."
+	fic, err := fictex.ParseString(input)
+	if err != nil {
+		t.Fatalf("parse: %s", err)
+	}
+
+	var got []string
+	for _, m := range NewChecker(testDictionary(t), "Mira").Check(fic, []byte(input)) {
+		got = append(got, fmt.Sprintf("%d-%d %s %v", m.Pos, m.End, input[m.Pos:m.End], m.Suggestions))
+	}
+	want := []string{"5-8 cta [cat]", "10-13 sat []", "14-16 on []", "40-42 by []", "50-53 cta [cat]"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Check = %q, want %q", got, want)
+	}
+}
+
+func TestCheckWrongPositions(t *testing.T) {
+	// The text is said to start after the end of the source
+	input := "a cta"
+	fic := fictex.Node{Type: fictex.Group, Child: []fictex.Node{
+		{Type: fictex.Paragraph, Child: []fictex.Node{
+			{Type: fictex.Text, Text: []byte(input), Pos: 3},
+		}},
+	}}
+
+	found := NewChecker(testDictionary(t)).Check(fic, []byte(input))
+	if len(found) == 0 {
+		t.Fatalf("Check found nothing")
+	}
+	for _, m := range found {
+		if m.Pos < 0 || m.Pos > m.End || m.End > len(input) {
+			t.Errorf("%q at %d-%d is not within %d bytes", m.Word, m.Pos, m.End, len(input))
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		Aff, Err string
+	}{
+		{"SET ISO8859-1", "spell: aff:1: unsupported encoding ISO8859-1"},
+		{"FLAG wide", "spell: aff:1: unknown FLAG format wide"},
+		{"\nSFX S 0 s .", "spell: aff:2: SFX S has no header"},
+		{"PFX A Y 1\nPFX A 0 re [a", "spell: aff:2: unclosed [ in condition \"[a\""},
+	}
+	for _, test := range tests {
+		_, err := Load(strings.NewReader(test.Aff), strings.NewReader(""))
+		if err == nil || err.Error() != test.Err {
+			t.Errorf("Load(%q) = %v, want %s", test.Aff, err, test.Err)
+		}
+	}
+}
+
+func TestLongFlags(t *testing.T) {
+	aff := "FLAG long\nSFX Aa Y 1\nSFX Aa 0 s .\n"
+	d, err := Load(strings.NewReader(aff), strings.NewReader("1\ndog/BbAa\n"))
+	if err != nil {
+		t.Fatalf("load: %s", err)
+	}
+	if !d.known("dogs") {
+		t.Errorf("dogs is not known")
+	}
+}
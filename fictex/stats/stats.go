@@ -0,0 +1,202 @@
+// Package stats counts the words, characters, and paragraphs of a fictex
+// document and estimates how long it takes to read.
+package stats
+
+import (
+	"time"
+	"unicode"
+
+	"fictex"
+)
+
+// Reading speeds used to estimate the reading time.  Chinese and Japanese
+// are not written with spaces, so each of their characters counts as a
+// word and is read at a different speed.
+const (
+	WordsPerMinute = 230
+	CJKPerMinute   = 400
+)
+
+// Stats describes the text of a document without any markup.
+type Stats struct {
+	Words      int
+	Characters int // Not counting spaces
+	Paragraphs int // Including each stanza of verse
+	Dialogue   int // Words spoken in quotes or after a dialogue dash
+
+	ReadingTime time.Duration
+
+	cjk int // Words which are CJK characters
+}
+
+// DialogueRatio returns the fraction of the words which are dialogue.
+func (s Stats) DialogueRatio() float64 {
+	if s.Words == 0 {
+		return 0
+	}
+	return float64(s.Dialogue) / float64(s.Words)
+}
+
+// Add adds the counts of o to s.
+func (s *Stats) Add(o Stats) {
+	s.Words += o.Words
+	s.Characters += o.Characters
+	s.Paragraphs += o.Paragraphs
+	s.Dialogue += o.Dialogue
+	s.cjk += o.cjk
+	s.ReadingTime = readingTime(s.Words-s.cjk, s.cjk)
+}
+
+// A Chapter gives the statistics of a section of a document.
+type Chapter struct {
+	Title string // Empty for any text before the first chapter
+	Pos   int    // The position of the chapter's heading in the source, or 0
+	Stats
+}
+
+// Count returns the statistics of a document.  Preview text and scene
+// breaks are not counted; footnotes, headings, and attributions are counted
+// as words but not as paragraphs.
+func Count(n fictex.Node) Stats {
+	var s Stats
+	fictex.Inspect(n, func(n fictex.Node) bool {
+		switch n.Type {
+		case fictex.Paragraph:
+			s.Paragraphs++
+			s.text(fictex.TextContent(n))
+		case fictex.Verse:
+			s.Paragraphs++
+			return true
+		case fictex.Line, fictex.Heading, fictex.Attribution:
+			s.text(fictex.TextContent(n))
+		case fictex.Footnote:
+			// TextContent skips footnotes, so their text is joined here
+			var text string
+			for _, c := range n.Child {
+				text += fictex.TextContent(c)
+			}
+			s.text(text)
+		default:
+			return true
+		}
+		return false
+	})
+	s.ReadingTime = readingTime(s.Words-s.cjk, s.cjk)
+	return s
+}
+
+// Chapters divides a document at its outermost headings and returns the
+// statistics of each part.  Text before the first heading is returned as a
+// chapter without a title.  A document without headings is one chapter.
+// Each chapter's statistics include its heading.
+func Chapters(n fictex.Node) []Chapter {
+	top := []fictex.Node{n}
+	if n.Type == fictex.Group {
+		top = n.Child
+	}
+
+	level := 0
+	for _, c := range top {
+		if c.Type == fictex.Heading && (level == 0 || c.Level < level) {
+			level = c.Level
+		}
+	}
+
+	var chapters []Chapter
+	var body []fictex.Node
+	title, pos, started := "", 0, false
+	flush := func() {
+		if started || len(body) > 0 {
+			s := Count(fictex.Node{Type: fictex.Group, Child: body})
+			chapters = append(chapters, Chapter{title, pos, s})
+		}
+		body = nil
+	}
+	for _, c := range top {
+		if c.Type == fictex.Heading && c.Level == level {
+			flush()
+			title, pos, started = fictex.TextContent(c), c.Pos, true
+		}
+		body = append(body, c)
+	}
+	flush()
+	return chapters
+}
+
+// text counts the words and characters of the text of a block.  A block
+// which starts with an em dash is dialogue until the next em dash, as in
+// French and Russian; otherwise double quotes and guillemets start and end
+// dialogue.  Single quotes are not counted because they cannot be told
+// apart from apostrophes.
+func (s *Stats) text(text string) {
+	var close rune // The quote which ends the dialogue, if any
+	dialogue, dashed := false, false
+	inWord, started := false, false
+	for _, r := range text {
+		switch {
+		case unicode.IsSpace(r):
+			inWord = false
+			continue
+		case r == '—':
+			if !started {
+				dashed = true
+			}
+			if dashed {
+				dialogue = !dialogue
+			}
+			inWord = false
+		case dashed:
+			s.word(r, &inWord, dialogue)
+		case dialogue && r == close:
+			dialogue, inWord = false, false
+		case !dialogue && closing[r] != 0:
+			dialogue, inWord, close = true, false, closing[r]
+		default:
+			s.word(r, &inWord, dialogue)
+		}
+		s.Characters++
+		started = true
+	}
+}
+
+// closing gives the closing quote for each quote which starts dialogue.
+var closing = map[rune]rune{
+	'"': '"',
+	'“': '”',
+	'«': '»',
+	'„': '“',
+}
+
+// word counts r if it starts a word.  Dashes separate words.
+func (s *Stats) word(r rune, inWord *bool, dialogue bool) {
+	switch {
+	case r == '–' || r == '—':
+		*inWord = false
+	case isCJK(r):
+		s.Words++
+		s.cjk++
+		if dialogue {
+			s.Dialogue++
+		}
+		*inWord = false
+	case unicode.IsLetter(r) || unicode.IsDigit(r):
+		if !*inWord {
+			s.Words++
+			if dialogue {
+				s.Dialogue++
+			}
+		}
+		*inWord = true
+	}
+}
+
+// isCJK reports whether r is written without spaces between words.  Korean
+// is written with spaces and so is not included.
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana)
+}
+
+func readingTime(words, cjk int) time.Duration {
+	minutes := float64(words)/WordsPerMinute + float64(cjk)/CJKPerMinute
+	return time.Duration(minutes*60+0.5) * time.Second
+}
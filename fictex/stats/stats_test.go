@@ -0,0 +1,152 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"fictex"
+)
+
+var countTests = []struct {
+	Desc  string
+	Input string
+	Stats Stats
+}{
+	{
+		Desc:  "Markup",
+		Input: "*Bold* and /slant/---_under_ a--b\n\n-----\n\nlast[^1]",
+		Stats: Stats{Words: 7, Characters: 25, Paragraphs: 2},
+	},
+	{
+		Desc:  "Front matter and previews",
+		Input: "Title: Not counted\n\n<short\nlong text\n>",
+		Stats: Stats{Words: 2, Characters: 8, Paragraphs: 1},
+	},
+	{
+		Desc:  "Dialogue",
+		Input: `"Hello there," she said. "Goodbye."`,
+		Stats: Stats{Words: 5, Characters: 31, Paragraphs: 1, Dialogue: 3},
+	},
+	{
+		Desc:  "Unclosed dialogue",
+		Input: "\"One two\n\nthree \"four\"",
+		Stats: Stats{Words: 4, Characters: 18, Paragraphs: 2, Dialogue: 3},
+	},
+	{
+		Desc:  "German quotes",
+		Input: "„Er sagte“ nein.",
+		Stats: Stats{Words: 3, Characters: 14, Paragraphs: 1, Dialogue: 2},
+	},
+	{
+		Desc:  "Dialogue dash",
+		Input: "--- Привет, --- сказал он. --- Пока.",
+		Stats: Stats{Words: 4, Characters: 24, Paragraphs: 1, Dialogue: 2},
+	},
+	{
+		Desc:  "Verse and quotes",
+		Input: "| one\n| two\n\n> three\n> -- four",
+		Stats: Stats{Words: 4, Characters: 16, Paragraphs: 2},
+	},
+	{
+		Desc:  "Footnotes",
+		Input: "a[^1]\n\n[^1]: b c",
+		Stats: Stats{Words: 3, Characters: 3, Paragraphs: 1},
+	},
+	{
+		Desc:  "Markup in footnotes",
+		Input: "a[^1]\n\n[^1]: E=mc^2^ here",
+		Stats: Stats{Words: 3, Characters: 10, Paragraphs: 1},
+	},
+	{
+		Desc:  "CJK",
+		Input: "「こんにちは」と言った。Hello 世界",
+		Stats: Stats{Words: 12, Characters: 19, Paragraphs: 1, cjk: 11},
+	},
+}
+
+func TestCount(t *testing.T) {
+	for _, test := range countTests {
+		desc := test.Desc
+
+		fic, _, err := fictex.ParseDocumentBytes([]byte(test.Input))
+		if err != nil {
+			t.Fatalf("%s: parse: %s", desc, err)
+		}
+		want := test.Stats
+		want.ReadingTime = readingTime(want.Words-want.cjk, want.cjk)
+		if got := Count(fic); got != want {
+			t.Errorf("%s: Count = %+v, want %+v", desc, got, want)
+		}
+	}
+}
+
+func TestReadingTime(t *testing.T) {
+	if got, want := readingTime(WordsPerMinute*2, CJKPerMinute/2), 150*time.Second; got != want {
+		t.Errorf("readingTime = %v, want %v", got, want)
+	}
+
+	var s Stats
+	s.Add(Stats{Words: WordsPerMinute, Dialogue: 23})
+	s.Add(Stats{Words: CJKPerMinute, cjk: CJKPerMinute})
+	if got, want := s.ReadingTime, 2*time.Minute; got != want {
+		t.Errorf("Add: ReadingTime = %v, want %v", got, want)
+	}
+	if got, want := s.DialogueRatio(), 23.0/(WordsPerMinute+CJKPerMinute); got != want {
+		t.Errorf("DialogueRatio = %v, want %v", got, want)
+	}
+}
+
+var chapterTests = []struct {
+	Desc   string
+	Input  string
+	Titles []string
+	Words  []int
+}{
+	{
+		Desc:   "No headings",
+		Input:  "one two\n\nthree",
+		Titles: []string{""},
+		Words:  []int{3},
+	},
+	{
+		Desc:   "Prologue",
+		Input:  "a b\n\n## One\n\nc\n\n### Scene\n\nd\n\n## Two\n\ne f g",
+		Titles: []string{"", "One", "Two"},
+		Words:  []int{2, 4, 4},
+	},
+	{
+		Desc:   "Empty chapter",
+		Input:  "# One\n\n# Two\n\nx",
+		Titles: []string{"One", "Two"},
+		Words:  []int{1, 2},
+	},
+}
+
+func TestChapters(t *testing.T) {
+	for _, test := range chapterTests {
+		desc := test.Desc
+
+		fic, err := fictex.ParseString(test.Input)
+		if err != nil {
+			t.Fatalf("%s: parse: %s", desc, err)
+		}
+
+		chapters := Chapters(fic)
+		if got, want := len(chapters), len(test.Titles); got != want {
+			t.Fatalf("%s: %d chapters, want %d", desc, got, want)
+		}
+		total := 0
+		for i, ch := range chapters {
+			if got, want := ch.Title, test.Titles[i]; got != want {
+				t.Errorf("%s: chapter %d title = %q, want %q", desc, i, got, want)
+			}
+			if got, want := ch.Words, test.Words[i]; got != want {
+				t.Errorf("%s: chapter %d words = %d, want %d", desc, i, got, want)
+			}
+			total += ch.Words
+		}
+		if got, want := total, Count(fic).Words; got != want {
+			t.Errorf("%s: chapters have %d words, document has %d", desc, got, want)
+		}
+	}
+}
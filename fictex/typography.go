@@ -0,0 +1,305 @@
+package fictex
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Typography describes how plain keyboard punctuation is replaced by its
+// typeset form.  The zero Typography changes nothing.
+type Typography struct {
+	// The quotation marks used for the outermost quotes and for quotes
+	// nested inside them; deeper quotes alternate between the two
+	Primary   StringPair
+	Secondary StringPair
+
+	// Apostrophe replaces a ' which is not a quotation mark
+	Apostrophe string
+
+	// Ellipsis replaces "..."
+	Ellipsis string
+
+	// DashSpace replaces a space before an m-dash, and DialogueSpace the
+	// space after an m-dash which starts a paragraph
+	DashSpace     string
+	DialogueSpace string
+
+	// QuoteSpace is added inside Primary quotation marks
+	QuoteSpace string
+
+	// PunctSpace is put before each of the punctuation marks in SpaceBefore
+	// which ends a word, replacing any space typed there
+	PunctSpace  string
+	SpaceBefore string
+}
+
+// English is the typography of published English fiction.
+var English = Typography{
+	Primary:    StringPair{"“", "”"},
+	Secondary:  StringPair{"‘", "’"},
+	Apostrophe: "’",
+	Ellipsis:   "…",
+}
+
+// French typography uses guillemets and puts thin spaces before high
+// punctuation.
+var French = Typography{
+	Primary:       StringPair{"«", "»"},
+	Secondary:     StringPair{"“", "”"},
+	Apostrophe:    "’",
+	Ellipsis:      "…",
+	DashSpace:     "\u00a0",
+	DialogueSpace: "\u00a0",
+	QuoteSpace:    "\u00a0",
+	PunctSpace:    "\u202f",
+	SpaceBefore:   ";:!?",
+}
+
+// German typography uses low opening quotation marks.
+var German = Typography{
+	Primary:    StringPair{"„", "“"},
+	Secondary:  StringPair{"‚", "‘"},
+	Apostrophe: "’",
+	Ellipsis:   "…",
+	DashSpace:  "\u00a0",
+}
+
+// Russian typography uses guillemets, with German quotes nested inside,
+// and m-dashes for dialogue.
+var Russian = Typography{
+	Primary:       StringPair{"«", "»"},
+	Secondary:     StringPair{"„", "“"},
+	Apostrophe:    "’",
+	Ellipsis:      "…",
+	DashSpace:     "\u00a0",
+	DialogueSpace: "\u00a0",
+}
+
+// Locales maps language codes to their typography.
+var Locales = map[string]*Typography{
+	"en": &English,
+	"fr": &French,
+	"de": &German,
+	"ru": &Russian,
+}
+
+// Elisions lists the words which begin with an apostrophe instead of an
+// opening quotation mark, like 'tis.  Years like '90s are always elided.
+var Elisions = []string{
+	"tis", "twas", "twere", "twill", "em", "til", "cause", "bout",
+	"round", "n", "nuff", "ere", "neath", "ow", "ello", "cept",
+}
+
+// Apply returns a copy of the document with the typography applied to its
+// text.  Quotes are matched within each paragraph, line, or attribution,
+// including across formatting; a quote which is not closed within it does
+// not affect the next one.
+func (t Typography) Apply(n Node) Node {
+	if n.Type == Preview && len(n.Text) > 0 {
+		n.Text = t.inline([]Node{{Type: Text, Text: n.Text}})[0].Text
+	}
+	if len(n.Child) == 0 {
+		return n
+	}
+
+	child := make([]Node, len(n.Child))
+	copy(child, n.Child)
+	for _, c := range child {
+		if hasText(c) {
+			n.Child = t.inline(child)
+			return n
+		}
+	}
+	for i, c := range child {
+		child[i] = t.Apply(c)
+	}
+	n.Child = child
+	return n
+}
+
+// hasText returns true for the nodes which make up the text of a block.
+func hasText(n Node) bool {
+	switch n.Type {
+	case Text, Bold, Slant, Underline, Strike, Super, SmallCaps, Spoiler:
+		return true
+	}
+	return false
+}
+
+// inline applies the typography to a run of inline nodes, which are
+// modified in place.
+func (t Typography) inline(nodes []Node) []Node {
+	// Flatten the text so that quotes can be matched across nodes.  The
+	// owner of each rune is the index of the node it came from, negated and
+	// less one for a rune which stands in for a node without text.
+	var runes []rune
+	var owner []int
+	for i, n := range nodes {
+		switch {
+		case hasText(n):
+			for _, r := range string(n.Text) {
+				runes = append(runes, r)
+				owner = append(owner, i)
+			}
+		case n.Type == NDash || n.Type == MDash:
+			runes = append(runes, '—')
+			owner = append(owner, -i-1)
+		case n.Type == LineBreak:
+			runes = append(runes, '\n')
+			owner = append(owner, -i-1)
+		}
+	}
+
+	at := func(i int) rune {
+		if i < 0 || i >= len(runes) {
+			return ' '
+		}
+		return runes[i]
+	}
+
+	out := make([][]byte, len(nodes))
+	emit := func(i int, s string) {
+		out[owner[i]] = append(out[owner[i]], s...)
+	}
+
+	var open []rune // The quotation marks which are open, innermost last
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if owner[i] < 0 {
+			continue
+		}
+
+		switch {
+		case r == '.' && t.Ellipsis != "" && at(i+1) == '.' && at(i+2) == '.' &&
+			owner[i+1] == owner[i] && owner[i+2] == owner[i]:
+			emit(i, t.Ellipsis)
+			i += 2
+			continue
+		case r == ' ' && t.DashSpace != "" && i+1 < len(runes) && owner[i+1] < 0 &&
+			nodes[-owner[i+1]-1].Type == MDash:
+			emit(i, t.DashSpace)
+			continue
+		case r == ' ' && t.DialogueSpace != "" && i == 1 && owner[0] < 0 &&
+			nodes[-owner[0]-1].Type == MDash:
+			emit(i, t.DialogueSpace)
+			continue
+		case r == ' ' && t.PunctSpace != "" && t.spaceBefore(at(i+1)) && !isWordRune(at(i+2)):
+			emit(i, t.PunctSpace)
+			i++
+			emit(i, string(runes[i]))
+			continue
+		case i > 0 && t.PunctSpace != "" && t.spaceBefore(r) && !isWordRune(at(i+1)) &&
+			!unicode.IsSpace(at(i-1)) && !t.spaceBefore(at(i-1)):
+			emit(i, t.PunctSpace+string(r))
+			continue
+		case r != '"' && r != '\'':
+			var buf [utf8.UTFMax]byte
+			emit(i, string(buf[:utf8.EncodeRune(buf[:], r)]))
+			continue
+		}
+
+		prev, next := at(i-1), at(i+1)
+		opening := unicode.IsSpace(prev) || isOpener(prev)
+
+		switch {
+		case r == '\'' && isWordRune(prev) && isWordRune(next):
+			emit(i, t.apostrophe(r))
+		case r == '\'' && opening && elided(runes[i+1:]):
+			emit(i, t.apostrophe(r))
+		case opening && !unicode.IsSpace(next):
+			emit(i, t.marks(len(open), r)[0])
+			if len(open) == 0 {
+				emit(i, t.QuoteSpace)
+			}
+			open = append(open, r)
+		default:
+			depth := -1
+			for j := len(open) - 1; j >= 0; j-- {
+				if open[j] == r {
+					depth = j
+					break
+				}
+			}
+			switch {
+			case depth >= 0:
+				if depth == 0 {
+					emit(i, t.QuoteSpace)
+				}
+				emit(i, t.marks(depth, r)[1])
+				open = open[:depth]
+			case r == '\'':
+				emit(i, t.apostrophe(r))
+			default:
+				emit(i, t.QuoteSpace+t.marks(0, r)[1])
+			}
+		}
+	}
+
+	for i := range nodes {
+		if hasText(nodes[i]) {
+			nodes[i].Text = out[i]
+		}
+	}
+	return nodes
+}
+
+// marks returns the quotation marks for the given depth of nesting, or the
+// original mark r if there are none.
+func (t Typography) marks(depth int, r rune) StringPair {
+	pair := t.Primary
+	if depth%2 == 1 && t.Secondary != (StringPair{}) {
+		pair = t.Secondary
+	}
+	if pair == (StringPair{}) {
+		return StringPair{string(r), string(r)}
+	}
+	return pair
+}
+
+// spaceBefore returns true if r is one of the marks in SpaceBefore.
+func (t Typography) spaceBefore(r rune) bool {
+	return strings.ContainsRune(t.SpaceBefore, r)
+}
+
+func (t Typography) apostrophe(r rune) string {
+	if t.Apostrophe == "" {
+		return string(r)
+	}
+	return t.Apostrophe
+}
+
+// isOpener returns true for the punctuation after which a quote opens.
+func isOpener(r rune) bool {
+	switch r {
+	case '(', '[', '{', '—', '–', '-', '/':
+		return true
+	}
+	return unicode.Is(unicode.Ps, r) || unicode.Is(unicode.Pi, r)
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// elided returns true if the text after an opening ' is an Elision or a
+// year like '90s.
+func elided(rest []rune) bool {
+	end := 0
+	for end < len(rest) && isWordRune(rest[end]) {
+		end++
+	}
+	if end == 0 {
+		return false
+	}
+	word := string(rest[:end])
+	if end >= 2 && unicode.IsDigit(rest[0]) && unicode.IsDigit(rest[1]) {
+		return true
+	}
+	for _, e := range Elisions {
+		if strings.EqualFold(word, e) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package fictex
+
+import (
+	"bytes"
+	"testing"
+)
+
+var typographyTests = []struct {
+	Desc   string
+	Typo   Typography
+	Input  string
+	Output string
+}{
+	{
+		Desc:   "Dialogue",
+		Typo:   English,
+		Input:  `"Hello," she said. "Goodbye."`,
+		Output: "\n    “Hello,” she said. “Goodbye.”\n",
+	},
+	{
+		Desc:   "Nested",
+		Typo:   English,
+		Input:  `"He said 'no' to me."`,
+		Output: "\n    “He said ‘no’ to me.”\n",
+	},
+	{
+		Desc:   "Apostrophes",
+		Typo:   English,
+		Input:  `'Tis the dogs' bone from the '90s, don't 'em.`,
+		Output: "\n    ’Tis the dogs’ bone from the ’90s, don’t ’em.\n",
+	},
+	{
+		Desc:   "Across formatting",
+		Typo:   English,
+		Input:  `"/Never/," he said. *"Ever."*`,
+		Output: "\n    “/Never/,” he said. *“Ever.”*\n",
+	},
+	{
+		Desc:   "After a dash",
+		Typo:   English,
+		Input:  `I---"Stop!"`,
+		Output: "\n    I---“Stop!”\n",
+	},
+	{
+		Desc:   "Ellipsis",
+		Typo:   English,
+		Input:  "Well... maybe.",
+		Output: "\n    Well… maybe.\n",
+	},
+	{
+		Desc:   "Dash space",
+		Typo:   Typography{DashSpace: "\u00a0"},
+		Input:  `"Wait" --- she said... 'no'`,
+		Output: "\n    \"Wait\"\u00a0--- she said... 'no'\n",
+	},
+	{
+		Desc:   "Unclosed quote",
+		Typo:   English,
+		Input:  "\"One\n\n\"Two\"",
+		Output: "\n    “One\n\n    “Two”\n",
+	},
+	{
+		Desc:   "Preview",
+		Typo:   English,
+		Input:  "<\"Hi\"\nthere\n>",
+		Output: "\n<<“Hi”\n    there\n>>\n",
+	},
+	{
+		Desc:   "French",
+		Typo:   French,
+		Input:  "--- \"Viens ici!\" dit-il. \"Pourquoi ?\" Il est 10:30; c'est l'heure...",
+		Output: "\n    ---\u00a0«\u00a0Viens ici\u202f!\u00a0» dit-il. «\u00a0Pourquoi\u202f?\u00a0» Il est 10:30\u202f; c’est l’heure…\n",
+	},
+	{
+		Desc:   "German",
+		Typo:   German,
+		Input:  `"Er sagte 'nein' --- oder?"`,
+		Output: "\n    „Er sagte ‚nein‘\u00a0--- oder?“\n",
+	},
+	{
+		Desc:   "Russian",
+		Typo:   Russian,
+		Input:  `--- Привет, --- сказал он. "Книга 'Война и мир'".`,
+		Output: "\n    ---\u00a0Привет,\u00a0--- сказал он. «Книга „Война и мир“».\n",
+	},
+}
+
+func TestTypography(t *testing.T) {
+	for _, test := range typographyTests {
+		desc := test.Desc
+
+		fic, err := ParseBytes([]byte(test.Input))
+		if err != nil {
+			t.Fatalf("%s: parse: %s", desc, err)
+		}
+		before := fic.String()
+
+		r := TextRenderer
+		r.Typography = &test.Typo
+		b := new(bytes.Buffer)
+		if err := r.Render(b, fic); err != nil {
+			t.Fatalf("%s: render: %s", desc, err)
+		}
+		if got, want := b.String(), test.Output; got != want {
+			t.Errorf("%s: render = %q, want %q", desc, got, want)
+		}
+
+		if after := fic.String(); after != before {
+			t.Errorf("%s: document was modified:\n%s", desc, after)
+		}
+	}
+}
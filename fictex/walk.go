@@ -0,0 +1,198 @@
+package fictex
+
+import "errors"
+
+// SkipChildren may be returned by the enter function of a Walk to skip the
+// children of the current node.  It is not returned by Walk.
+var SkipChildren = errors.New("skip children")
+
+// A WalkFunc is called by Walk for each node.  An error other than
+// SkipChildren stops the walk.
+type WalkFunc func(c *Cursor) error
+
+// A Cursor describes the node being visited by Walk and can change it.
+// Nodes which are inserted are not visited.
+type Cursor struct {
+	node   Node
+	parent *Node
+	index  int
+	depth  int
+
+	changed bool
+	deleted bool
+	before  []Node
+	after   []Node
+}
+
+// Node returns the node being visited.  When leaving a node, its children
+// reflect any changes made to them.
+func (c *Cursor) Node() Node { return c.node }
+
+// Parent returns the parent of the node as it was before the walk, or the
+// zero Node for the root.
+func (c *Cursor) Parent() Node {
+	if c.parent == nil {
+		return Node{}
+	}
+	return *c.parent
+}
+
+// Index returns the index of the node among the children of its parent.
+func (c *Cursor) Index() int { return c.index }
+
+// Depth returns the number of ancestors of the node.
+func (c *Cursor) Depth() int { return c.depth }
+
+// Replace replaces the node.  If called on entering a node, the children of
+// the replacement are visited instead.
+func (c *Cursor) Replace(n Node) {
+	c.node, c.changed, c.deleted = n, true, false
+}
+
+// Delete removes the node.  Its children are not visited.
+func (c *Cursor) Delete() {
+	c.changed, c.deleted = true, true
+}
+
+// InsertBefore inserts nodes before the node.
+func (c *Cursor) InsertBefore(n ...Node) {
+	c.changed = true
+	c.before = append(c.before, n...)
+}
+
+// InsertAfter inserts nodes after the node.
+func (c *Cursor) InsertAfter(n ...Node) {
+	c.changed = true
+	c.after = append(c.after, n...)
+}
+
+// Walk visits n and its descendants in document order, calling enter before
+// and leave after visiting the children of each node.  Either may be nil.
+// Leave is called even if enter returns SkipChildren.
+//
+// The returned node reflects any changes made through the Cursor; n itself
+// is not modified.  If the root is deleted or has nodes inserted beside it,
+// the result is a Group of what remains.
+func Walk(n Node, enter, leave WalkFunc) (Node, error) {
+	c := &Cursor{node: n}
+	err := walk(c, enter, leave)
+	if err != nil || !c.changed {
+		return c.node, err
+	}
+	if !c.deleted && len(c.before) == 0 && len(c.after) == 0 {
+		return c.node, nil
+	}
+
+	root := Node{Type: Group}
+	root.Child = append(root.Child, c.before...)
+	if !c.deleted {
+		root.Child = append(root.Child, c.node)
+	}
+	root.Child = append(root.Child, c.after...)
+	return root, nil
+}
+
+func walk(c *Cursor, enter, leave WalkFunc) error {
+	if enter != nil {
+		switch err := enter(c); err {
+		case nil:
+		case SkipChildren:
+			return visited(c, leave)
+		default:
+			return err
+		}
+	}
+	if c.deleted {
+		return nil
+	}
+
+	// Only copy the children if one of them changes
+	parent := c.node
+	var child []Node
+	for i, n := range parent.Child {
+		cc := &Cursor{node: n, parent: &parent, index: i, depth: c.depth + 1}
+		if err := walk(cc, enter, leave); err != nil {
+			return err
+		}
+		if cc.changed && child == nil {
+			child = append(make([]Node, 0, len(parent.Child)), parent.Child[:i]...)
+		}
+		if child == nil {
+			continue
+		}
+		child = append(child, cc.before...)
+		if !cc.deleted {
+			child = append(child, cc.node)
+		}
+		child = append(child, cc.after...)
+	}
+	if child != nil {
+		c.node.Child = child
+		c.changed = true
+	}
+
+	return visited(c, leave)
+}
+
+func visited(c *Cursor, leave WalkFunc) error {
+	if leave == nil || c.deleted {
+		return nil
+	}
+	if err := leave(c); err != SkipChildren {
+		return err
+	}
+	return nil
+}
+
+// Inspect calls f for n and each of its descendants in document order.  If
+// f returns false, the children of the node are skipped.
+func Inspect(n Node, f func(Node) bool) {
+	Walk(n, func(c *Cursor) error {
+		if !f(c.Node()) {
+			return SkipChildren
+		}
+		return nil
+	}, nil)
+}
+
+// Find returns the nodes of the given type within n, in document order.
+func Find(n Node, typ nodeType) []Node {
+	var found []Node
+	Inspect(n, func(n Node) bool {
+		if n.Type == typ {
+			found = append(found, n)
+		}
+		return true
+	})
+	return found
+}
+
+// TextContent returns the text of n and its descendants without any
+// formatting.  Dashes are written as unicode dashes, and line breaks and the
+// breaks between paragraphs, headings, and lines of verse as newlines.
+// Preview text, scene break glyphs, the indentation of verse, and footnotes
+// are skipped.
+func TextContent(n Node) string {
+	var b []byte
+	Inspect(n, func(n Node) bool {
+		switch n.Type {
+		case NDash:
+			b = append(b, "–"...)
+		case MDash:
+			b = append(b, "—"...)
+		case LineBreak:
+			b = append(b, '\n')
+		case Paragraph, Line, Heading, Attribution:
+			if len(b) > 0 && b[len(b)-1] != '\n' {
+				b = append(b, '\n')
+			}
+		case Footnote:
+			return false
+		case Preview, HLine, FootnoteRef:
+		default:
+			b = append(b, n.Text...)
+		}
+		return true
+	})
+	return string(b)
+}
@@ -0,0 +1,219 @@
+package fictex
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var walkTests = []struct {
+	Desc   string
+	Input  string
+	Enter  func(c *Cursor) error
+	Leave  func(c *Cursor) error
+	Output string // The source of the expected document
+}{
+	{
+		Desc:   "Unchanged",
+		Input:  "a *b*\n\nc",
+		Enter:  func(c *Cursor) error { return nil },
+		Output: "a *b*\n\nc",
+	},
+	{
+		Desc:  "Replace",
+		Input: "a *b*\n\nc",
+		Enter: func(c *Cursor) error {
+			if n := c.Node(); n.Type == Bold {
+				c.Replace(Node{Type: Slant, Text: n.Text})
+			}
+			return nil
+		},
+		Output: "a /b/\n\nc",
+	},
+	{
+		Desc:  "Delete",
+		Input: "a\n\n-----\n\nb\n\n-----\n\nc",
+		Enter: func(c *Cursor) error {
+			if c.Node().Type == HLine {
+				c.Delete()
+			}
+			return nil
+		},
+		Output: "a\n\nb\n\nc",
+	},
+	{
+		Desc:  "Insert",
+		Input: "a\n\nb",
+		Leave: func(c *Cursor) error {
+			if c.Node().Type == Paragraph && c.Index() == 0 {
+				c.InsertBefore(Node{Type: Heading, Level: 1, Child: []Node{{Type: Text, Text: []byte("Start")}}})
+				c.InsertAfter(Node{Type: HLine})
+			}
+			return nil
+		},
+		Output: "# Start\n\na\n\n-----\n\nb",
+	},
+	{
+		Desc:  "Skip children",
+		Input: "> *a*\n\n*b*",
+		Enter: func(c *Cursor) error {
+			switch n := c.Node(); n.Type {
+			case Quote:
+				return SkipChildren
+			case Bold:
+				c.Replace(Node{Type: Text, Text: n.Text})
+			}
+			return nil
+		},
+		Output: "> *a*\n\nb",
+	},
+	{
+		Desc:  "Delete root",
+		Input: "a",
+		Enter: func(c *Cursor) error {
+			if c.Depth() == 0 {
+				c.InsertAfter(Node{Type: HLine})
+				c.Delete()
+			}
+			return nil
+		},
+		Output: "-----",
+	},
+}
+
+func TestWalk(t *testing.T) {
+	for _, test := range walkTests {
+		desc := test.Desc
+
+		fic, err := ParseString(test.Input)
+		if err != nil {
+			t.Fatalf("%s: parse: %s", desc, err)
+		}
+		before := fic.String()
+
+		got, err := Walk(fic, test.Enter, test.Leave)
+		if err != nil {
+			t.Fatalf("%s: walk: %s", desc, err)
+		}
+		want, err := ParseString(test.Output)
+		if err != nil {
+			t.Fatalf("%s: parse output: %s", desc, err)
+		}
+		if got, want := withoutPos(got), withoutPos(want); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Walk tree mismatch:", desc)
+			t.Logf("Got:\n%s", got)
+			t.Logf("Want:\n%s", want)
+		}
+
+		if after := fic.String(); after != before {
+			t.Errorf("%s: document was modified:\n%s", desc, after)
+		}
+	}
+}
+
+func TestWalkOrder(t *testing.T) {
+	fic, err := ParseString("a *b* c\n\n> d")
+	if err != nil {
+		t.Fatalf("parse: %s", err)
+	}
+
+	var events []string
+	visit := func(prefix string) WalkFunc {
+		return func(c *Cursor) error {
+			events = append(events, prefix+c.Node().Type.String())
+			return nil
+		}
+	}
+	if _, err := Walk(fic, visit("+"), visit("-")); err != nil {
+		t.Fatalf("walk: %s", err)
+	}
+
+	got := strings.Join(events, " ")
+	want := "+Group +Paragraph +Text -Text +Bold -Bold +Text -Text -Paragraph " +
+		"+Quote +Paragraph +Text -Text -Paragraph -Quote -Group"
+	if got != want {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+
+	stop := errors.New("stop")
+	events = nil
+	_, err = Walk(fic, func(c *Cursor) error {
+		if c.Node().Type == Bold {
+			return stop
+		}
+		return visit("+")(c)
+	}, nil)
+	if err != stop {
+		t.Errorf("walk = %v, want %v", err, stop)
+	}
+	if got, want := len(events), 3; got != want {
+		t.Errorf("visited %d nodes before stopping, want %d", got, want)
+	}
+}
+
+var textContentTests = []struct {
+	Desc  string
+	Input string
+	Text  string
+}{
+	{
+		Desc:  "Formatting",
+		Input: "a *b* /c/--d---e",
+		Text:  "a b c–d—e",
+	},
+	{
+		Desc:  "Footnotes",
+		Input: "a[^1] b\n\n[^1]: note",
+		Text:  "a b",
+	},
+	{
+		Desc:  "Preview",
+		Input: "<short\nlong\n>",
+		Text:  "long",
+	},
+	{
+		Desc:  "Line breaks",
+		Input: "a\\\nb",
+		Text:  "a\nb",
+	},
+	{
+		Desc:  "Paragraphs",
+		Input: "# A\n\nend of line\n\nnext line\n\n> q\n>\n> -- me",
+		Text:  "A\nend of line\nnext line\nq\nme",
+	},
+	{
+		Desc:  "Verse",
+		Input: "| end of line\n|   next line",
+		Text:  "end of line\nnext line",
+	},
+}
+
+func TestTextContent(t *testing.T) {
+	for _, test := range textContentTests {
+		desc := test.Desc
+
+		fic, err := ParseString(test.Input)
+		if err != nil {
+			t.Fatalf("%s: parse: %s", desc, err)
+		}
+		if got, want := TextContent(fic), test.Text; got != want {
+			t.Errorf("%s: TextContent = %q, want %q", desc, got, want)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	fic, err := ParseString("*a* b\n\n> *c*\n\n*d*")
+	if err != nil {
+		t.Fatalf("parse: %s", err)
+	}
+
+	var got []string
+	for _, n := range Find(fic, Bold) {
+		got = append(got, string(n.Text))
+	}
+	if want := []string{"a", "c", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Find(Bold) = %q, want %q", got, want)
+	}
+}
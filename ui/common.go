@@ -73,7 +73,17 @@ type Unauthorized string
 func (e Unauthorized) Error() string { return string(e) + ": unauthorized" }
 func (e Unauthorized) ErrorCode() int { return http.StatusUnauthorized }
 
+type BadRequest string
+
+func (e BadRequest) Error() string  { return string(e) + ": bad request" }
+func (e BadRequest) ErrorCode() int { return http.StatusBadRequest }
+
 type NotFound string
 
 func (e NotFound) Error() string { return string(e) + ": not found" }
 func (e NotFound) ErrorCode() int { return http.StatusNotFound }
+
+type Conflict string
+
+func (e Conflict) Error() string  { return string(e) + ": edited elsewhere" }
+func (e Conflict) ErrorCode() int { return http.StatusConflict }
@@ -0,0 +1,222 @@
+package ui
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"appengine"
+	"appengine/datastore"
+
+	"fictex/spell"
+)
+
+// Set up the handlers
+
+func init() {
+	http.Handle("/dictionary", Wrapper(UserDictionary))
+}
+
+// dictionaryDir holds the Hunspell dictionaries, named like en_US.aff and
+// en_US.dic.  The first dictionary for a language's code is used.
+const dictionaryDir = "dictionaries"
+
+// dictionaries caches the dictionary of each language code.  A language
+// without a dictionary is cached as nil.
+var dictionaries = struct {
+	sync.Mutex
+	m map[string]*spell.Dictionary
+}{m: map[string]*spell.Dictionary{}}
+
+// SpellDictionary returns the dictionary of the named language, or nil if
+// there is none.  A story without a language is in English.
+func SpellDictionary(language string) (*spell.Dictionary, error) {
+	if language == "" {
+		language = "English"
+	}
+	code := ""
+	for name, c := range languages {
+		if strings.EqualFold(name, language) {
+			code = c
+		}
+	}
+	if code == "" {
+		return nil, nil
+	}
+
+	dictionaries.Lock()
+	defer dictionaries.Unlock()
+	if d, ok := dictionaries.m[code]; ok {
+		return d, nil
+	}
+
+	d, err := loadDictionary(code)
+	if err != nil {
+		return nil, err
+	}
+	dictionaries.m[code] = d
+	return d, nil
+}
+
+func loadDictionary(code string) (*spell.Dictionary, error) {
+	affs, err := filepath.Glob(filepath.Join(dictionaryDir, code+"_*.aff"))
+	if err != nil || len(affs) == 0 {
+		return nil, err
+	}
+	sort.Strings(affs)
+
+	aff, err := os.Open(affs[0])
+	if err != nil {
+		return nil, err
+	}
+	defer aff.Close()
+	dic, err := os.Open(strings.TrimSuffix(affs[0], ".aff") + ".dic")
+	if err != nil {
+		return nil, err
+	}
+	defer dic.Close()
+	return spell.Load(aff, dic)
+}
+
+// A WordList is the words a user has added to their dictionary.
+type WordList struct {
+	Words []string
+}
+
+func wordListKey(c appengine.Context, user *datastore.Key) *datastore.Key {
+	return datastore.NewKey(c, "WordList", "dictionary", 0, user)
+}
+
+// UserWords returns the words in a user's dictionary.
+func UserWords(c appengine.Context, user *datastore.Key) ([]string, error) {
+	l := new(WordList)
+	switch err := datastore.Get(c, wordListKey(c, user), l); err {
+	case nil, datastore.ErrNoSuchEntity:
+	default:
+		return nil, err
+	}
+	return l.Words, nil
+}
+
+// UserDictionary adds a word to or removes a word from the user's
+// dictionary, and responds with the words in it.
+func UserDictionary(c appengine.Context, w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	_, k := UserKey(c)
+	word := strings.TrimSpace(r.Form.Get("word"))
+	action := r.Form.Get("action")
+	if action != "" && (word == "" || strings.ContainsAny(word, " \t\n,")) {
+		return BadRequest("word must be a single word")
+	}
+
+	l := new(WordList)
+	err := datastore.RunInTransaction(c, func(tx appengine.Context) error {
+		key := wordListKey(tx, k)
+		switch err := datastore.Get(tx, key, l); err {
+		case nil, datastore.ErrNoSuchEntity:
+		default:
+			return err
+		}
+
+		i := sort.SearchStrings(l.Words, word)
+		found := i < len(l.Words) && l.Words[i] == word
+		switch action {
+		case "":
+			return nil
+		case "add":
+			if found {
+				return nil
+			}
+			l.Words = append(l.Words[:i], append([]string{word}, l.Words[i:]...)...)
+		case "remove":
+			if !found {
+				return nil
+			}
+			l.Words = append(l.Words[:i], l.Words[i+1:]...)
+		default:
+			return NotFound(action)
+		}
+		_, err := datastore.Put(tx, key, l)
+		return err
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if action != "" {
+		c.Infof("Dictionary: %s %q", action, word)
+	}
+
+	words := l.Words
+	if words == nil {
+		words = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	return json.NewEncoder(w).Encode(words)
+}
+
+// storyChecker returns a spell checker for a story in the named language
+// which accepts the words in the user's dictionary and in the story's
+// dictionary and characters headers.
+func storyChecker(c appengine.Context, user *datastore.Key, language, dictionary, characters string) (*spell.Checker, error) {
+	dict, err := SpellDictionary(language)
+	if err != nil {
+		return nil, err
+	}
+	if dict == nil {
+		return nil, NotFound("dictionary for " + language)
+	}
+	words, err := UserWords(c, user)
+	if err != nil {
+		return nil, err
+	}
+	words = append(words, storyWords(dictionary, characters)...)
+	return spell.NewChecker(dict, words...), nil
+}
+
+// storyWords returns the words which are correct in a story besides those
+// in the dictionary: those in its dictionary header and the names in its
+// characters header.
+func storyWords(dictionary, characters string) []string {
+	words := splitList(dictionary)
+	for _, name := range splitList(characters) {
+		words = append(words, strings.Fields(name)...)
+	}
+	return words
+}
+
+// misspellingJSON is a misspelled word.  Pos and End are byte offsets in
+// the source; From and To are the same offsets in UTF-16 code units.
+type misspellingJSON struct {
+	Word        string   `json:"word"`
+	Pos         int      `json:"pos"`
+	End         int      `json:"end"`
+	From        int      `json:"from"`
+	To          int      `json:"to"`
+	Suggestions []string `json:"suggestions"`
+}
+
+func spellingJSON(source string, misspellings []spell.Misspelling) []misspellingJSON {
+	out := []misspellingJSON{}
+	for _, m := range misspellings {
+		s := m.Suggestions
+		if s == nil {
+			s = []string{}
+		}
+		out = append(out, misspellingJSON{
+			Word:        m.Word,
+			Pos:         m.Pos,
+			End:         m.End,
+			From:        utf16Len(source[:m.Pos]),
+			To:          utf16Len(source[:m.End]),
+			Suggestions: s,
+		})
+	}
+	return out
+}
@@ -0,0 +1,86 @@
+package ui
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"appengine"
+	"fictex"
+)
+
+// Set up the handlers
+
+func init() {
+	http.Handle("/export/", Wrapper(ExportPage))
+}
+
+// headerNode returns a fictex document listing a title and headers, in
+// order, followed by a separator.
+func headerNode(title string, headers []Header) fictex.Node {
+	n := fictex.Node{Type: fictex.Group}
+
+	line := func(label, value string) {
+		n.Child = append(n.Child, fictex.Node{
+			Type: fictex.Paragraph,
+			Child: []fictex.Node{
+				{Type: fictex.Bold, Text: []byte(label + ":")},
+				{Type: fictex.Text, Text: []byte(" " + value)},
+			},
+		})
+	}
+
+	if title != "" {
+		line("Title", title)
+	}
+	for _, h := range headers {
+		line(h.Label, h.Value)
+	}
+
+	n.Child = append(n.Child, fictex.Node{Type: fictex.HLine})
+	return n
+}
+
+// Export renders the story's headers followed by the story itself.
+func Export(w io.Writer, s *Story, r fictex.Renderer) error {
+	node, _, err := fictex.ParseDocumentBytes(s.Source)
+	if err != nil {
+		return err
+	}
+	if err := r.Render(w, headerNode(s.Title, s.Headers(false))); err != nil {
+		return err
+	}
+	return r.Render(w, node)
+}
+
+// ExportPage sends a story, with its headers, in the requested format as
+// plain text suitable for copying elsewhere.
+func ExportPage(c appengine.Context, w http.ResponseWriter, r *http.Request) error {
+	var id string
+	if strings.HasPrefix(r.URL.Path, "/export/") {
+		id = r.URL.Path[len("/export/"):]
+	} else {
+		return NotFound(r.URL.Path)
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	renderer := fictex.TextRenderer
+	if r, ok := Renderers[r.Form.Get("format")]; ok {
+		renderer = r
+	}
+
+	_, k := UserKey(c)
+	s := NewStory(c, id, k)
+	if err := s.Get(c); err != nil {
+		return NotFound(r.URL.Path)
+	}
+	if r.Form.Get("typography") != "" {
+		renderer.Typography = s.Typography()
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	return Export(w, s, renderer)
+}
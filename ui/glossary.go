@@ -0,0 +1,299 @@
+package ui
+
+import (
+	"encoding/json"
+	"html"
+	"net/http"
+	"strings"
+
+	"appengine"
+	"appengine/datastore"
+
+	"fictex"
+	"fictex/glossary"
+)
+
+// Set up the handlers
+
+func init() {
+	http.Handle("/glossary/", Wrapper(GlossaryPage))
+	http.Handle("/glossary", Wrapper(Glossary))
+}
+
+// A GlossaryEntry is a character, place, or term in a story.  Entries are
+// stored under the story, like its Property metadata.
+type GlossaryEntry struct {
+	Name    string
+	Kind    string // glossary.Character, glossary.Place, or glossary.Term
+	Aliases []string
+	Notes   string
+}
+
+// glossaryKinds are the kinds of entries, in the order they are listed.
+var glossaryKinds = []string{glossary.Character, glossary.Place, glossary.Term}
+
+// glossaryKey returns the key of an entry with the given name.  Names which
+// differ only in case are the same entry.
+func glossaryKey(c appengine.Context, owner *datastore.Key, name string) *datastore.Key {
+	return datastore.NewKey(c, "GlossaryEntry", strings.ToLower(name), 0, owner)
+}
+
+// LoadGlossary returns the glossary entries of each owner in turn, such as
+// a story.
+func LoadGlossary(c appengine.Context, owners ...*datastore.Key) ([]GlossaryEntry, error) {
+	var entries []GlossaryEntry
+	for _, owner := range owners {
+		q := datastore.NewQuery("GlossaryEntry")
+		q.Ancestor(owner)
+		q.Order("Name")
+		if _, err := q.GetAll(c, &entries); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// toGlossary returns the glossary of a list of entries.  Words which are
+// Known are never taken for misspelled names.
+func toGlossary(entries []GlossaryEntry, known func(string) bool) *glossary.Glossary {
+	g := &glossary.Glossary{Known: known}
+	for _, e := range entries {
+		g.Entries = append(g.Entries, glossary.Entry{Name: e.Name, Kind: e.Kind, Aliases: e.Aliases})
+	}
+	return g
+}
+
+// FillCharacters adds the characters in the story's glossary which are
+// named in its text to its characters header, after any which are already
+// listed, and returns the new value of the header.  It returns "" if no
+// characters were added.  Only a character's full name or an alias counts,
+// since a single word of a name like "Will" may start any sentence.
+func (s *Story) FillCharacters(c appengine.Context) (string, error) {
+	entries, err := LoadGlossary(c, s.key)
+	if err != nil || len(entries) == 0 {
+		return "", err
+	}
+	node, _, err := fictex.ParseDocumentBytes(s.Source)
+	if err != nil {
+		return "", nil
+	}
+
+	// The default is replaced rather than added to
+	var values []string
+	field, _ := SchemaField("characters")
+	if prop := s.Meta["characters"]; prop != nil && prop.Value != field.Default {
+		values = splitList(prop.Value)
+	}
+	listed := map[string]bool{}
+	for _, v := range values {
+		listed[strings.ToLower(v)] = true
+	}
+
+	kinds := map[string]string{}
+	for _, e := range entries {
+		kinds[e.Name] = e.Kind
+	}
+	added := false
+	g := toGlossary(entries, nil)
+	g.WholeNames = true
+	for _, m := range g.Mentions(node, s.Source) {
+		if kinds[m.Name] != glossary.Character || listed[strings.ToLower(m.Name)] {
+			continue
+		}
+		listed[strings.ToLower(m.Name)] = true
+		values = append(values, m.Name)
+		added = true
+	}
+	if !added {
+		return "", nil
+	}
+
+	value := strings.Join(values, ", ")
+	s.NewProperty(c, "characters", value).Values = values
+	return value, nil
+}
+
+// GlossaryPage shows the glossary of a story and what it finds in the text.
+func GlossaryPage(c appengine.Context, w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/xhtml+xml; charset=UTF-8")
+
+	id := r.URL.Path[len("/glossary/"):]
+	_, k := UserKey(c)
+	s := NewStory(c, id, k)
+	if id == "" || id == "autosave" || datastore.Get(c, s.key, s) != nil {
+		return NotFound(r.URL.Path)
+	}
+
+	return templates.ExecuteTemplate(w, "glossary.html", map[string]interface{}{
+		"Id":    html.EscapeString(id),
+		"Title": html.EscapeString(s.Title),
+	})
+}
+
+// Glossary adds, changes, or removes an entry in a story's glossary and
+// responds with the glossary, the near misses of its names in the story's
+// saved text, and the entries named in each chapter.
+func Glossary(c appengine.Context, w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	_, k := UserKey(c)
+	s := NewStory(c, r.Form.Get("story"), k)
+	if err := s.Get(c); err != nil {
+		return NotFound(s.ID)
+	}
+
+	name := strings.TrimSpace(r.Form.Get("name"))
+	switch action := r.Form.Get("action"); action {
+	case "":
+	case "set":
+		e := GlossaryEntry{
+			Name:    name,
+			Kind:    r.Form.Get("kind"),
+			Aliases: splitList(r.Form.Get("aliases")),
+			Notes:   strings.TrimSpace(r.Form.Get("notes")),
+		}
+		if e.Name == "" || len(e.Name) > maxValue {
+			return BadRequest("name must not be empty")
+		}
+		if e.Kind == "" {
+			e.Kind = glossary.Character
+		}
+		if !isGlossaryKind(e.Kind) {
+			return BadRequest("kind must be one of " + strings.Join(glossaryKinds, ", "))
+		}
+		if _, err := datastore.Put(c, glossaryKey(c, s.key, e.Name), &e); err != nil {
+			return err
+		}
+		c.Infof("Glossary of %s: set %q", s.ID, e.Name)
+	case "delete":
+		err := datastore.Delete(c, glossaryKey(c, s.key, name))
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		c.Infof("Glossary of %s: deleted %q", s.ID, name)
+	default:
+		return NotFound(action)
+	}
+
+	entries, err := LoadGlossary(c, s.key)
+	if err != nil {
+		return err
+	}
+	out, err := glossaryReport(c, s, entries, s.Source)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	return json.NewEncoder(w).Encode(out)
+}
+
+func isGlossaryKind(kind string) bool {
+	for _, k := range glossaryKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// glossaryJSON is the JSON encoding of a glossary and what it finds in the
+// source of a story.
+type glossaryJSON struct {
+	Entries    []entryJSON    `json:"entries"`
+	NearMisses []nearMissJSON `json:"nearMisses"`
+	Chapters   []castJSON     `json:"chapters"`
+}
+
+type entryJSON struct {
+	Name    string   `json:"name"`
+	Kind    string   `json:"kind"`
+	Aliases []string `json:"aliases"`
+	Notes   string   `json:"notes,omitempty"`
+}
+
+// nearMissJSON is a word spelled like a name.  Pos and End are byte offsets
+// in the source; From and To are the same offsets in UTF-16 code units.
+type nearMissJSON struct {
+	Word  string `json:"word"`
+	Want  string `json:"want"`
+	Entry string `json:"entry"`
+	Pos   int    `json:"pos"`
+	End   int    `json:"end"`
+	From  int    `json:"from"`
+	To    int    `json:"to"`
+}
+
+type castJSON struct {
+	Title string      `json:"title,omitempty"`
+	Names []countJSON `json:"names"`
+}
+
+type countJSON struct {
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+	Mentions int    `json:"mentions"`
+}
+
+// glossaryReport finds the names of a story's glossary in a source, which
+// may be newer than the story's own.  Words in the story's dictionary for
+// its language are not taken for misspelled names.
+func glossaryReport(c appengine.Context, s *Story, entries []GlossaryEntry, source []byte) (glossaryJSON, error) {
+	out := glossaryJSON{Entries: []entryJSON{}, NearMisses: []nearMissJSON{}, Chapters: []castJSON{}}
+	kinds := map[string]string{}
+	for _, e := range entries {
+		aliases := e.Aliases
+		if aliases == nil {
+			aliases = []string{}
+		}
+		out.Entries = append(out.Entries, entryJSON{e.Name, e.Kind, aliases, e.Notes})
+		kinds[e.Name] = e.Kind
+	}
+
+	node, fm, err := fictex.ParseDocumentBytes(source)
+	if err != nil {
+		return out, err
+	}
+	header := func(name string) string {
+		if v := fm.Get(name); v != "" {
+			return v
+		}
+		if prop := s.Meta[name]; prop != nil {
+			return prop.Value
+		}
+		return ""
+	}
+
+	var known func(string) bool
+	checker, err := storyChecker(c, s.key.Parent(), header("language"), header("dictionary"), "")
+	switch err.(type) {
+	case nil:
+		known = checker.Correct
+	case NotFound:
+	default:
+		return out, err
+	}
+
+	g := toGlossary(entries, known)
+	text := string(source)
+	for _, m := range g.NearMisses(node, source) {
+		out.NearMisses = append(out.NearMisses, nearMissJSON{
+			Word:  m.Word,
+			Want:  m.Want,
+			Entry: m.Name,
+			Pos:   m.Pos,
+			End:   m.End,
+			From:  utf16Len(text[:m.Pos]),
+			To:    utf16Len(text[:m.End]),
+		})
+	}
+	for _, cast := range g.Casts(node, source) {
+		cj := castJSON{Title: cast.Title, Names: []countJSON{}}
+		for _, n := range cast.Names {
+			cj.Names = append(cj.Names, countJSON{n.Name, kinds[n.Name], n.Mentions})
+		}
+		out.Chapters = append(out.Chapters, cj)
+	}
+	return out, nil
+}
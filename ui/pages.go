@@ -11,6 +11,8 @@ import (
 
 	"appengine"
 	"fictex"
+	"fictex/lint"
+	"fictex/stats"
 )
 
 // Set up the handlers
@@ -19,8 +21,10 @@ func init() {
 	http.Handle("/", Wrapper(Edit))
 	http.Handle("/edit/", Wrapper(Edit))
 	http.Handle("/read/", Wrapper(Read))
+	http.Handle("/diff/", Wrapper(Diff))
 	http.Handle("/ajax", Wrapper(Ajax))
 	http.Handle("/save", Wrapper(Save))
+	http.Handle("/manage", Wrapper(Manage))
 }
 
 // Set up the pages
@@ -37,19 +41,28 @@ func Edit(c appengine.Context, w http.ResponseWriter, r *http.Request) error {
 		return NotFound(r.URL.Path)
 	}
 
+	type choice struct {
+		Value    string
+		Selected bool
+	}
 	type metadata struct {
-		Id    string
-		Label string
-		Value string
+		Id       string
+		Label    string
+		Value    string
+		Hint     string
+		Choices  []choice
+		Computed bool
 	}
 	type maindata struct {
 		// Story
-		Id    string
-		Title string
-		Meta  []metadata
+		Id      string
+		Title   string
+		Version int64
+		Meta    []metadata
 
 		// Story list
 		Stories string
+		Archive string
 
 		// Preview
 		Source        string
@@ -69,24 +82,36 @@ func Edit(c appengine.Context, w http.ResponseWriter, r *http.Request) error {
 	} else {
 		data.Title = html.EscapeString(s.Title)
 		data.Id = html.EscapeString(id)
+		data.Version = s.Version
 	}
 
-	for name, prop := range s.Meta {
-		if len(name) == 0 || len(prop.Name) == 0 {
-			c.Warningf("Zero-length property name?")
-			continue
+	for _, h := range s.Headers(true) {
+		m := metadata{
+			Id:       html.EscapeString(h.Name),
+			Label:    html.EscapeString(h.Label),
+			Value:    html.EscapeString(h.Value),
+			Computed: h.Kind == Computed,
 		}
-		data.Meta = append(data.Meta, metadata{
-			Id:    html.EscapeString(name),
-			Label: html.EscapeString(strings.ToUpper(prop.Name[:1]) + prop.Name[1:]),
-			Value: html.EscapeString(prop.Value),
-		})
+		switch h.Kind {
+		case Choice:
+			m.Choices = append(m.Choices, choice{})
+			for _, v := range h.Choices {
+				m.Choices = append(m.Choices, choice{html.EscapeString(v), v == h.Value})
+			}
+		case Choices:
+			m.Hint = html.EscapeString("Any of: " + strings.Join(h.Choices, ", "))
+		case List:
+			m.Hint = "Separate values with commas"
+		}
+		data.Meta = append(data.Meta, m)
 	}
 
 	data.Source = html.EscapeString(string(s.Source))
-	if node, err := fictex.ParseBytes(s.Source); err == nil {
+	if node, _, err := fictex.ParseDocumentBytes(s.Source); err == nil {
+		renderer := fictex.HTMLRenderer
+		renderer.Typography = s.Typography()
 		b := new(bytes.Buffer)
-		if err := fictex.HTMLRenderer.Render(b, node); err == nil {
+		if err := renderer.Render(b, node); err == nil {
 			data.PreviewHTML = b.String()
 		}
 	}
@@ -97,6 +122,11 @@ func Edit(c appengine.Context, w http.ResponseWriter, r *http.Request) error {
 	} else {
 		data.Stories = string(js)
 	}
+	if js, err := JSONArchiveList(c, k); err != nil {
+		c.Warningf("Failed to load archived stories: %s", err)
+	} else {
+		data.Archive = string(js)
+	}
 
 	return templates.ExecuteTemplate(w, "edit.html", data)
 }
@@ -115,10 +145,28 @@ func Read(c appengine.Context, w http.ResponseWriter, r *http.Request) error {
 		Label string
 		Value string
 	}
-	type renderdata struct {
+	type section struct {
+		Level int
 		Title string
-		Meta  []metadata
-		HTML  string
+		ID    string
+	}
+
+	type part struct {
+		URL   string
+		Title string
+	}
+	type series struct {
+		Title      string
+		Part       int
+		Prev, Next *part
+	}
+
+	type renderdata struct {
+		Title    string
+		Meta     []metadata
+		Contents []section
+		Series   *series
+		HTML     string
 	}
 
 	var data renderdata
@@ -128,22 +176,64 @@ func Read(c appengine.Context, w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	// Send old slugs to the current one
+	if s.Slug != "" && id != s.Slug && id != s.ID {
+		w.Header().Del("Content-Type")
+		http.Redirect(w, r, readURL(s.Slug), http.StatusMovedPermanently)
+		return nil
+	}
+
 	data.Title = html.EscapeString(s.Title)
 
-	for name, prop := range s.Meta {
-		if len(name) == 0 || len(prop.Name) == 0 {
-			c.Warningf("Zero-length property name?")
-			continue
-		}
+	for _, h := range s.Headers(false) {
 		data.Meta = append(data.Meta, metadata{
-			Label: html.EscapeString(strings.ToUpper(prop.Name[:1]) + prop.Name[1:]),
-			Value: html.EscapeString(prop.Value),
+			Label: html.EscapeString(h.Label),
+			Value: html.EscapeString(h.Value),
 		})
 	}
 
-	if node, err := fictex.ParseBytes(s.Source); err == nil {
+	// Link to the other parts of the story's series
+	if se, err := SeriesOf(c, s.key.Parent(), s.ID); err != nil {
+		c.Warningf("Failed to find the series of %s: %s", s.ID, err)
+	} else if se != nil {
+		parts, err := se.LiveParts(c)
+		if err != nil {
+			return err
+		}
+		link := func(p SeriesPart) *part {
+			return &part{html.EscapeString(p.URL()), html.EscapeString(p.Title)}
+		}
+		for i, p := range parts {
+			if p.ID != s.ID {
+				continue
+			}
+			data.Series = &series{Title: html.EscapeString(se.Title), Part: i + 1}
+			if i > 0 {
+				data.Series.Prev = link(parts[i-1])
+			}
+			if i+1 < len(parts) {
+				data.Series.Next = link(parts[i+1])
+			}
+			break
+		}
+	}
+
+	if node, _, err := fictex.ParseDocumentBytes(s.Source); err == nil {
+		for _, sec := range fictex.Outline(node) {
+			data.Contents = append(data.Contents, section{
+				Level: sec.Level,
+				Title: html.EscapeString(sec.Title),
+				ID:    sec.ID,
+			})
+		}
+
+		// Typography is applied if the story or the link asks for it
+		renderer := fictex.HTMLRenderer
+		if s.SmartTypography() || r.FormValue("typography") != "" {
+			renderer.Typography = s.Typography()
+		}
 		b := new(bytes.Buffer)
-		if err := fictex.HTMLRenderer.Render(b, node); err == nil {
+		if err := renderer.Render(b, node); err == nil {
 			data.HTML = b.String()
 		}
 	} else {
@@ -153,6 +243,75 @@ func Read(c appengine.Context, w http.ResponseWriter, r *http.Request) error {
 	return templates.ExecuteTemplate(w, "render.html", data)
 }
 
+// Diff shows the changes between the saved copy of a story and either the
+// source posted from the editor or, if there is none, the story named by
+// the "with" parameter (the autosave by default).
+func Diff(c appengine.Context, w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/xhtml+xml; charset=UTF-8")
+
+	var id string
+	if strings.HasPrefix(r.URL.Path, "/diff/") {
+		id = r.URL.Path[len("/diff/"):]
+	} else {
+		return NotFound(r.URL.Path)
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	type diffdata struct {
+		Id    string
+		Title string
+		With  string
+		HTML  string
+	}
+
+	var data diffdata
+
+	_, k := UserKey(c)
+	s := NewStory(c, id, k)
+	if err := s.Get(c); err != nil {
+		return NotFound(r.URL.Path)
+	}
+
+	data.Id = html.EscapeString(id)
+	data.Title = html.EscapeString(s.Title)
+
+	source, ok := r.Form["source"]
+	if !ok {
+		with := r.Form.Get("with")
+		if with == "" {
+			with = "autosave"
+		}
+		other := NewStory(c, with, k)
+		if err := other.Get(c); err != nil {
+			return NotFound(with)
+		}
+		// The autosave normally has no title of its own
+		switch {
+		case with == "autosave":
+			data.With = "the autosave"
+		case other.Title == "":
+			data.With = "an untitled story"
+		default:
+			data.With = html.EscapeString(other.Title)
+		}
+		source = []string{string(other.Source)}
+	}
+
+	if d, err := fictex.DiffBytes(s.Source, []byte(source[0])); err == nil {
+		b := new(bytes.Buffer)
+		if err := fictex.HTMLDiffRenderer.Render(b, d); err == nil {
+			data.HTML = b.String()
+		}
+	} else {
+		data.HTML = html.EscapeString(fmt.Sprintf("Error: %s", err))
+	}
+
+	return templates.ExecuteTemplate(w, "diff.html", data)
+}
+
 func Ajax(c appengine.Context, w http.ResponseWriter, r *http.Request) error {
 	if err := r.ParseForm(); err != nil {
 		return err
@@ -165,13 +324,106 @@ func Ajax(c appengine.Context, w http.ResponseWriter, r *http.Request) error {
 
 	switch action := r.Form.Get("action"); action {
 	case "render":
-		node, err := fictex.ParseString(r.Form.Get("source"))
+		node, fm, err := fictex.ParseDocument(strings.NewReader(r.Form.Get("source")))
 		if err != nil {
 			return err
 		}
+		if r.Form.Get("typography") != "" {
+			language := fm.Get("language")
+			if language == "" {
+				language = r.Form.Get("language")
+			}
+			renderer.Typography = Typography(language)
+		}
 		if err := renderer.Render(w, node); err != nil {
 			return err
 		}
+	case "diff":
+		d, err := fictex.DiffBytes([]byte(r.Form.Get("old")), []byte(r.Form.Get("source")))
+		if err != nil {
+			return err
+		}
+		differ := fictex.HTMLDiffRenderer
+		if r, ok := DiffRenderers[r.Form.Get("format")]; ok {
+			differ = r
+		}
+		if err := differ.Render(w, d); err != nil {
+			return err
+		}
+	case "ast":
+		// Positions are relative to the start of the source, including any
+		// front matter
+		node, _, err := fictex.ParseDocument(strings.NewReader(r.Form.Get("source")))
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		return fictex.EncodeJSON(w, node)
+	case "stats":
+		node, _, err := fictex.ParseDocument(strings.NewReader(r.Form.Get("source")))
+		if err != nil {
+			return err
+		}
+		out := struct {
+			statsJSON
+			Chapters []statsJSON `json:"chapters"`
+		}{statsJSON: toStatsJSON("", stats.Count(node))}
+		for _, ch := range stats.Chapters(node) {
+			out.Chapters = append(out.Chapters, toStatsJSON(ch.Title, ch.Stats))
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		return json.NewEncoder(w).Encode(out)
+	case "lint":
+		source := r.Form.Get("source")
+		node, _, err := fictex.ParseDocument(strings.NewReader(source))
+		if err != nil {
+			return err
+		}
+		cfg := lint.DefaultConfig
+		cfg.Disabled = map[string]bool{}
+		for _, name := range strings.Split(r.Form.Get("disable"), ",") {
+			cfg.Disabled[strings.TrimSpace(name)] = true
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		return json.NewEncoder(w).Encode(lintJSON(source, cfg.Lint(node, []byte(source))))
+	case "spell":
+		// Headers in the source take precedence over those posted from the
+		// Info pane
+		source := r.Form.Get("source")
+		node, fm, err := fictex.ParseDocument(strings.NewReader(source))
+		if err != nil {
+			return err
+		}
+		header := func(name string) string {
+			if v := fm.Get(name); v != "" {
+				return v
+			}
+			return r.Form.Get(name)
+		}
+		_, k := UserKey(c)
+		checker, err := storyChecker(c, k, header("language"), header("dictionary"), header("characters"))
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		return json.NewEncoder(w).Encode(spellingJSON(source, checker.Check(node, []byte(source))))
+	case "names":
+		// The glossary is the saved story's, but the source may be newer
+		_, k := UserKey(c)
+		s := NewStory(c, r.Form.Get("id"), k)
+		if err := s.Get(c); err != nil {
+			return NotFound(s.ID)
+		}
+		entries, err := LoadGlossary(c, s.key)
+		if err != nil {
+			return err
+		}
+		out, err := glossaryReport(c, s, entries, []byte(r.Form.Get("source")))
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		return json.NewEncoder(w).Encode(out)
 	default:
 		fmt.Fprintln(w, "Unknown action", action)
 	}
@@ -179,8 +431,47 @@ func Ajax(c appengine.Context, w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// findingJSON is a lint finding.  Pos and End are byte offsets in the
+// source; From and To are the same offsets in UTF-16 code units, as used by
+// a textarea's selection.
+type findingJSON struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	Pos     int    `json:"pos"`
+	End     int    `json:"end"`
+	From    int    `json:"from"`
+	To      int    `json:"to"`
+}
+
+func lintJSON(source string, findings []lint.Finding) []findingJSON {
+	out := []findingJSON{}
+	for _, f := range findings {
+		out = append(out, findingJSON{
+			Rule:    f.Rule,
+			Message: f.Message,
+			Pos:     f.Pos,
+			End:     f.End,
+			From:    utf16Len(source[:f.Pos]),
+			To:      utf16Len(source[:f.End]),
+		})
+	}
+	return out
+}
+
+// utf16Len returns the length of s in UTF-16 code units.
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n++
+		if r >= 0x10000 {
+			n++
+		}
+	}
+	return n
+}
+
 func Save(c appengine.Context, w http.ResponseWriter, r *http.Request) (e error) {
-	out := map[string]string{}
+	out := map[string]interface{}{}
 	in := map[string]interface{}{}
 
 	data, err := ioutil.ReadAll(r.Body)
@@ -193,15 +484,39 @@ func Save(c appengine.Context, w http.ResponseWriter, r *http.Request) (e error)
 
 	id, _ := in["id"].(string)
 	source, _ := in["source"].(string)
+	version, _ := in["version"].(float64) // JSON numbers are floats
+	offset, _ := in["tz"].(float64)       // The editor's time zone offset in minutes
 
 	meta, _ := in["meta"].(map[string]interface{})
 	refreshStories := false
+
+	// Headers in the source take precedence over the Info pane
+	if _, fm, err := fictex.ParseDocumentBytes([]byte(source)); err == nil && len(fm.Keys) > 0 {
+		if meta == nil {
+			meta = map[string]interface{}{}
+		}
+		for _, key := range fm.Keys {
+			meta[key] = fm.Values[key]
+		}
+		out["meta"] = fm.Values
+	}
+
+	_, k := UserKey(c)
+
+	// The scratch story is moved into a new story when it is given a title
+	var scratch *Story
+
 	switch id {
 	case "", "autosave":
 		id = "autosave"
 		if title, _ := meta["title"].(string); title != "" {
+			scratch = NewStory(c, id, k)
+			if err := scratch.Get(c); err != nil {
+				scratch = nil
+			}
 			id = GenID(title)
 			out["id"] = id
+			version = 0
 			refreshStories = true
 			c.Infof("Creating a new story: %q as %s", title, id)
 		}
@@ -209,37 +524,118 @@ func Save(c appengine.Context, w http.ResponseWriter, r *http.Request) (e error)
 		out["id"] = id
 	}
 
-	_, k := UserKey(c)
 	s := NewStory(c, id, k)
 	s.Source = []byte(source)
+	s.Version = int64(version)
+	s.SetStats(Statistics(s.Source))
+
+	if scratch != nil {
+		for name, prop := range scratch.Meta {
+			s.NewProperty(c, name, prop.Value).Values = prop.Values
+		}
+	}
+
+	// Invalid headers are reported but do not prevent the story being saved
+	invalid := map[string]string{}
+
+	for prop, raw := range meta {
+		switch prop {
+		case "title":
+			title, _ := raw.(string)
+			if title == "" || id == "autosave" {
+				break
+			}
+			s.Title = title
+		default:
+			// Headers which are cleared or left at their default are not
+			// stored, and are removed if they were
+			val, _ := raw.(string)
+			if val == "" {
+				break
+			}
+			values, err := Validate(prop, val)
+			if err != nil {
+				invalid[prop] = err.(ValidationError).Message
+				s.Meta[prop] = nil // Keep the stored value
+				break
+			}
+			value := strings.Join(values, ", ")
+			if f, ok := SchemaField(prop); ok && value == f.Default {
+				break
+			}
+			s.NewProperty(c, prop, value).Values = values
+		}
+	}
+	if len(invalid) > 0 {
+		out["errors"] = invalid
+	}
+
+	if raw, ok := in["order"].([]interface{}); ok {
+		var order []string
+		for _, name := range raw {
+			if name, ok := name.(string); ok {
+				order = append(order, name)
+			}
+		}
+		s.Order = ValidOrder(order)
+	}
 
 	if id != "autosave" {
-		for prop, raw := range meta {
-			switch prop {
-			case "title":
-				title, _ := raw.(string)
-				if title == "" {
-					break
-				}
-				// TODO(kevlar): Use memcache to figure out if a story's name changes
-				/*
-					if s.Title != "" && s.Title != title {
-						refreshStories = true
-					}
-				*/
-				s.Title = title
-			default:
-				val, _ := raw.(string)
-				if val == "" {
-					break
-				}
-				s.NewProperty(c, prop, val)
+		characters, err := s.FillCharacters(c)
+		if err != nil {
+			c.Warningf("Failed to fill in the characters of %s: %s", id, err)
+		} else if characters != "" {
+			values, _ := out["meta"].(map[string]string)
+			if values == nil {
+				values = map[string]string{}
+				out["meta"] = values
 			}
+			values["characters"] = characters
 		}
 	}
 
 	if err := s.Put(c); err != nil {
-		return err
+		if _, ok := err.(Conflict); !ok {
+			return err
+		}
+		c.Infof("Rejecting stale save of %s at version %d", id, s.Version)
+		return conflict(c, w, NewStory(c, id, k))
+	}
+	out["version"] = s.Version
+
+	if s.Renamed() {
+		c.Infof("Story %s renamed to %q", id, s.Title)
+		refreshStories = true
+	}
+
+	// The scratch story's words were counted as they were written
+	added := s.Added()
+	if scratch != nil {
+		added -= scratch.Words
+	}
+	if err := RecordProgress(c, k, id, Today(int(offset)), added); err != nil {
+		c.Warningf("Failed to record the progress of %s: %s", id, err)
+	}
+
+	if scratch != nil {
+		if err := scratch.Delete(c); err != nil {
+			c.Warningf("Failed to clear the scratch story: %s", err)
+		}
+	}
+
+	if id != "autosave" {
+		if err := s.ClaimSlug(c); err != nil {
+			c.Warningf("Failed to claim a slug for %s: %s", id, err)
+		} else {
+			out["slug"] = s.Slug
+		}
+	}
+
+	// Series keep the title and slug of each of their stories
+	if s.Renamed() {
+		if err := refreshSeries(c, s); err != nil {
+			c.Warningf("Failed to update the series of %s: %s", id, err)
+		}
 	}
 
 	// Send a new list of stories
@@ -262,6 +658,99 @@ func Save(c appengine.Context, w http.ResponseWriter, r *http.Request) (e error)
 	return nil
 }
 
+// conflict responds to a stale save with the copy of the story which is
+// currently in the datastore so that the editor can offer to merge them.
+func conflict(c appengine.Context, w http.ResponseWriter, s *Story) error {
+	if err := s.Get(c); err != nil {
+		return err
+	}
+
+	meta := map[string]string{}
+	for name, prop := range s.Meta {
+		meta[name] = prop.Value
+	}
+
+	encoded, err := json.MarshalIndent(map[string]interface{}{
+		"id":      s.ID,
+		"title":   s.Title,
+		"source":  string(s.Source),
+		"version": s.Version,
+		"meta":    meta,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusConflict)
+	if _, err := w.Write(encoded); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Manage moves stories into and out of the archive and the trash.  It
+// responds with the new story lists.
+func Manage(c appengine.Context, w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	id := r.Form.Get("id")
+	if id == "" || id == "autosave" {
+		return NotFound(id)
+	}
+
+	_, k := UserKey(c)
+	s := NewStory(c, id, k)
+
+	var err error
+	switch action := r.Form.Get("action"); action {
+	case "delete":
+		err = s.Trash(c)
+	case "restore":
+		err = s.Restore(c)
+	case "archive":
+		err = s.Archive(c, true)
+	case "unarchive":
+		err = s.Archive(c, false)
+	case "purge":
+		// Only a story in the trash can be deleted for good
+		if err := s.Get(c); err != nil {
+			return NotFound(id)
+		}
+		if s.Deleted.IsZero() {
+			return BadRequest("only stories in the trash can be purged")
+		}
+		err = s.Delete(c)
+	default:
+		return NotFound(action)
+	}
+	if err != nil {
+		return err
+	}
+	c.Infof("Story %s: %s", id, r.Form.Get("action"))
+
+	out := map[string]string{}
+	if js, err := JSONStoryList(c, k); err == nil {
+		out["stories"] = string(js)
+	}
+	if js, err := JSONArchiveList(c, k); err == nil {
+		out["archive"] = string(js)
+	}
+
+	encoded, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if _, err := w.Write(encoded); err != nil {
+		return err
+	}
+	return nil
+}
+
 var LiveJournalRenderer = fictex.Renderer{
 	Escape: fictex.HTMLRenderer.Escape,
 
@@ -270,16 +759,48 @@ var LiveJournalRenderer = fictex.Renderer{
 	Underline: fictex.HTMLRenderer.Underline,
 	Paragraph: fictex.HTMLRenderer.Paragraph,
 
+	Strike:    fictex.HTMLRenderer.Strike,
+	Super:     fictex.HTMLRenderer.Super,
+	SmallCaps: fictex.StringPair{"<span style=\"font-variant: small-caps\">", "</span>"},
+	Spoiler:   fictex.StringPair{"<span style=\"background: #000; color: #000\" title=\"Spoiler\">", "</span>"},
+
+	Heading: fictex.HTMLRenderer.Heading,
+
+	Center: fictex.StringPair{"<p style=\"text-align: center\">\n", "\n</p>\n"},
+	Right:  fictex.StringPair{"<p style=\"text-align: right\">\n", "\n</p>\n"},
+
 	NDash: fictex.HTMLRenderer.NDash,
 	MDash: fictex.HTMLRenderer.MDash,
 	HLine: fictex.HTMLRenderer.HLine,
 
+	// Journal styles will not have the scenebreak class
+	SceneBreak: "<p style=\"text-align: center\">%s</p>\n",
+
 	Preview: fictex.StringPair{"<lj-cut text=%q>\n", "</lj-cut>\n"},
+
+	Note: fictex.HTMLRenderer.Note,
+
+	Quote:       fictex.HTMLRenderer.Quote,
+	Attribution: fictex.HTMLRenderer.Attribution,
+
+	Verse:       fictex.HTMLRenderer.Verse,
+	Line:        fictex.HTMLRenderer.Line,
+	VerseIndent: fictex.HTMLRenderer.VerseIndent,
+	LineBreak:   fictex.HTMLRenderer.LineBreak,
+
+	FootnoteRef: fictex.HTMLRenderer.FootnoteRef,
+	Footnote:    fictex.HTMLRenderer.Footnote,
+	Footnotes:   fictex.HTMLRenderer.Footnotes,
 }
 
 var Renderers = map[string]fictex.Renderer{
 	"html":   fictex.HTMLRenderer,
 	"lj":     LiveJournalRenderer,
 	"text":   fictex.TextRenderer,
-	"bbcode": fictex.TextRenderer,
+	"bbcode": fictex.BBCodeRenderer,
+}
+
+var DiffRenderers = map[string]fictex.DiffRenderer{
+	"html": fictex.HTMLDiffRenderer,
+	"text": fictex.TextDiffRenderer,
 }
@@ -0,0 +1,463 @@
+package ui
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+)
+
+// Set up the handlers
+
+func init() {
+	http.Handle("/progress", Wrapper(ProgressPage))
+	http.Handle("/progress/data", Wrapper(ProgressData))
+	http.Handle("/goals", Wrapper(Goals))
+}
+
+// dayFormat is the format of the days on which progress is recorded.  Days
+// in this format sort in order.
+const dayFormat = "2006-01-02"
+
+// chartDays is the number of days of progress which are charted by default.
+const chartDays = 30
+
+// rateDays is the number of recent days used to estimate how quickly a user
+// is writing.
+const rateDays = 7
+
+// Today returns the current day in a time zone given as minutes behind UTC,
+// as returned by JavaScript's Date.getTimezoneOffset.
+func Today(offset int) string {
+	return time.Now().UTC().Add(-time.Duration(offset) * time.Minute).Format(dayFormat)
+}
+
+// addDays returns the day n days after day.
+func addDays(day string, n int) string {
+	t, err := time.Parse(dayFormat, day)
+	if err != nil {
+		return day
+	}
+	return t.AddDate(0, 0, n).Format(dayFormat)
+}
+
+// daysBetween returns the number of days from a to b.
+func daysBetween(a, b string) int {
+	ta, err := time.Parse(dayFormat, a)
+	if err != nil {
+		return 0
+	}
+	tb, err := time.Parse(dayFormat, b)
+	if err != nil {
+		return 0
+	}
+	return int(tb.Sub(ta).Hours() / 24)
+}
+
+// A Progress records the words a user added to a story on one day.  Words
+// which were removed are subtracted, so a day's progress may be negative.
+type Progress struct {
+	Day   string // In dayFormat, in the user's time zone
+	Story string // The ID of the story
+	Words int
+}
+
+// RecordProgress adds words to the progress of a user's story on a day.
+func RecordProgress(c appengine.Context, user *datastore.Key, story, day string, words int) error {
+	if words == 0 {
+		return nil
+	}
+	key := datastore.NewKey(c, "Progress", day+"/"+story, 0, user)
+	return datastore.RunInTransaction(c, func(tx appengine.Context) error {
+		p := new(Progress)
+		switch err := datastore.Get(tx, key, p); err {
+		case nil, datastore.ErrNoSuchEntity:
+		default:
+			return err
+		}
+		p.Day, p.Story = day, story
+		p.Words += words
+		if _, err := datastore.Put(tx, key, p); err != nil {
+			return err
+		}
+
+		t := new(ProgressTotal)
+		tkey := progressTotalKey(tx, user)
+		switch err := datastore.Get(tx, tkey, t); err {
+		case nil, datastore.ErrNoSuchEntity:
+		default:
+			return err
+		}
+		t.add(day, words)
+		_, err := datastore.Put(tx, tkey, t)
+		return err
+	}, nil)
+}
+
+// A ProgressTotal sums up all of a user's progress, so that the totals and
+// streaks do not need every day's progress to be loaded.  Words are only
+// recorded on the current day, so the days before the last one are done.
+type ProgressTotal struct {
+	Words    int    // All the words recorded
+	Day      string // The last day on which words were recorded
+	DayWords int    // The words recorded on Day
+	Run      int    // The days in a row with words added up to the day before Day
+	Longest  int    // The longest streak which ended before Day
+}
+
+// progressTotalKey returns the key of a user's ProgressTotal.
+func progressTotalKey(c appengine.Context, user *datastore.Key) *datastore.Key {
+	return datastore.NewKey(c, "ProgressTotal", "all", 0, user)
+}
+
+// add records words written on a day.  A day before the last one, as after
+// a change of time zone, only adds to the total.
+func (t *ProgressTotal) add(day string, words int) {
+	t.Words += words
+	switch {
+	case t.Day == "" || day == t.Day:
+		t.Day = day
+		t.DayWords += words
+	case day > t.Day:
+		run := t.through()
+		if run > t.Longest {
+			t.Longest = run
+		}
+		if daysBetween(t.Day, day) == 1 {
+			t.Run = run
+		} else {
+			t.Run = 0
+		}
+		t.Day, t.DayWords = day, words
+	}
+}
+
+// through returns the number of days in a row with words added up to and
+// including Day.
+func (t *ProgressTotal) through() int {
+	if t.DayWords > 0 {
+		return t.Run + 1
+	}
+	return 0
+}
+
+// streaks returns the number of consecutive days up to today on which words
+// were added, and the longest such run.  The current streak is not broken
+// until a whole day passes without writing.
+func (t *ProgressTotal) streaks(today string) (current, longest int) {
+	run := t.through()
+	longest = t.Longest
+	if run > longest {
+		longest = run
+	}
+	switch {
+	case t.Day == today && run == 0:
+		current = t.Run
+	case t.Day == today || t.Day == addDays(today, -1):
+		current = run
+	}
+	return current, longest
+}
+
+// A Goal is a number of words a user means to write by a deadline.  A goal
+// for a story is met when the story is long enough; a goal for all of a
+// user's writing is met when enough words have been written since it
+// started.
+type Goal struct {
+	Story    string // The ID of the story, or empty for all stories
+	Words    int
+	Start    string // The day the goal was set
+	Deadline string // The last day of the goal, or empty for none
+}
+
+// goalKey returns the key of a user's goal for a story.
+func goalKey(c appengine.Context, user *datastore.Key, story string) *datastore.Key {
+	if story == "" {
+		story = "all"
+	}
+	return datastore.NewKey(c, "Goal", story, 0, user)
+}
+
+// Goals sets or removes one of the user's goals and responds with the same
+// data as ProgressData.
+func Goals(c appengine.Context, w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	_, k := UserKey(c)
+	offset, _ := strconv.Atoi(r.Form.Get("tz"))
+	story := r.Form.Get("story")
+	key := goalKey(c, k, story)
+
+	switch action := r.Form.Get("action"); action {
+	case "set":
+		g := Goal{Story: story, Start: Today(offset), Deadline: r.Form.Get("deadline")}
+		words, err := strconv.Atoi(r.Form.Get("words"))
+		if err != nil || words <= 0 {
+			return BadRequest("words must be a positive number")
+		}
+		g.Words = words
+		if g.Deadline != "" {
+			if _, err := time.Parse(dayFormat, g.Deadline); err != nil {
+				return BadRequest("deadline must be a date like " + dayFormat)
+			}
+			if g.Deadline < g.Start {
+				return BadRequest("deadline has passed")
+			}
+		}
+		if _, err := datastore.Put(c, key, &g); err != nil {
+			return err
+		}
+		c.Infof("Goal of %d words for %q by %q", g.Words, story, g.Deadline)
+	case "delete":
+		if err := datastore.Delete(c, key); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+	default:
+		return NotFound(action)
+	}
+
+	return writeProgress(c, w, k, offset, chartDays)
+}
+
+// ProgressPage shows a dashboard of the user's progress and goals.  The data
+// is loaded from ProgressData so that days are in the browser's time zone.
+func ProgressPage(c appengine.Context, w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/xhtml+xml; charset=UTF-8")
+
+	_, k := UserKey(c)
+	js, err := JSONStoryList(c, k)
+	if err != nil {
+		return err
+	}
+	return templates.ExecuteTemplate(w, "progress.html", map[string]interface{}{
+		"Stories": string(js),
+	})
+}
+
+// ProgressData responds with the user's daily progress, streaks, and goals
+// as JSON.  The tz parameter is the browser's time zone offset in minutes,
+// and days is the number of days to chart.
+func ProgressData(c appengine.Context, w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	offset, _ := strconv.Atoi(r.Form.Get("tz"))
+	days, err := strconv.Atoi(r.Form.Get("days"))
+	if err != nil || days <= 0 {
+		days = chartDays
+	}
+
+	_, k := UserKey(c)
+	return writeProgress(c, w, k, offset, days)
+}
+
+// dayJSON is the progress of one day in a chart.
+type dayJSON struct {
+	Day   string `json:"day"`
+	Words int    `json:"words"`
+	Total int    `json:"total"` // The words written up to and including the day
+}
+
+// goalJSON describes the progress towards a goal.  Projected is the day on
+// which the goal will be met at the recent rate of writing, if it will be.
+type goalJSON struct {
+	Story     string  `json:"story,omitempty"`
+	Title     string  `json:"title,omitempty"`
+	Words     int     `json:"words"`
+	Start     string  `json:"start"`
+	Deadline  string  `json:"deadline,omitempty"`
+	Written   int     `json:"written"`
+	Rate      float64 `json:"rate"`             // Words per day recently
+	Needed    int     `json:"needed,omitempty"` // Words per day to meet the deadline
+	Projected string  `json:"projected,omitempty"`
+	OnTrack   bool    `json:"onTrack"`
+	Done      bool    `json:"done"`
+}
+
+type progressJSON struct {
+	Today         string     `json:"today"`
+	TodayWords    int        `json:"todayWords"`
+	Streak        int        `json:"streak"`
+	LongestStreak int        `json:"longestStreak"`
+	Days          []dayJSON  `json:"days"`
+	Goals         []goalJSON `json:"goals"`
+}
+
+func writeProgress(c appengine.Context, w http.ResponseWriter, user *datastore.Key, offset, days int) error {
+	var goals []Goal
+	q := datastore.NewQuery("Goal")
+	q.Ancestor(user)
+	if _, err := q.GetAll(c, &goals); err != nil {
+		return err
+	}
+
+	total := new(ProgressTotal)
+	switch err := datastore.Get(c, progressTotalKey(c, user), total); err {
+	case nil, datastore.ErrNoSuchEntity:
+	default:
+		return err
+	}
+
+	// Only the days charted, those of the recent rate, and those since the
+	// start of a goal for all stories are needed
+	today := Today(offset)
+	since := addDays(today, 1-days)
+	if rated := addDays(today, 1-rateDays); rated < since {
+		since = rated
+	}
+	for _, g := range goals {
+		if g.Story == "" && g.Start < since {
+			since = g.Start
+		}
+	}
+
+	var progress []Progress
+	q = datastore.NewQuery("Progress")
+	q.Ancestor(user)
+	q.Filter("Day >=", since)
+	if _, err := q.GetAll(c, &progress); err != nil {
+		return err
+	}
+
+	// The goals for stories need the stories' lengths and titles
+	stories := map[string]*Story{}
+	for _, g := range goals {
+		if g.Story == "" {
+			continue
+		}
+		s := NewStory(c, g.Story, user)
+		if err := datastore.Get(c, s.key, s); err != nil {
+			c.Warningf("Goal for missing story %s: %s", g.Story, err)
+			continue
+		}
+		stories[g.Story] = s
+	}
+
+	encoded, err := json.MarshalIndent(summarize(progress, total, goals, stories, today, days), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if _, err := w.Write(encoded); err != nil {
+		return err
+	}
+	return nil
+}
+
+// summarize computes the dashboard data from a user's progress and goals.
+// The progress need only go back to the start of the chart and of the goals.
+func summarize(progress []Progress, all *ProgressTotal, goals []Goal, stories map[string]*Story, today string, days int) progressJSON {
+	out := progressJSON{Today: today}
+
+	total := map[string]int{}
+	for _, p := range progress {
+		total[p.Day] += p.Words
+	}
+	out.TodayWords = total[today]
+	out.Streak, out.LongestStreak = all.streaks(today)
+
+	// The running total starts with the words written before the chart
+	start := addDays(today, 1-days)
+	sum := all.Words
+	for day, words := range total {
+		if day >= start {
+			sum -= words
+		}
+	}
+	for i := 0; i < days; i++ {
+		day := addDays(start, i)
+		sum += total[day]
+		out.Days = append(out.Days, dayJSON{day, total[day], sum})
+	}
+
+	for _, g := range goals {
+		gj := goalJSON{Story: g.Story, Words: g.Words, Start: g.Start, Deadline: g.Deadline}
+
+		// Only count the days of the goal, and only the story's if it has one
+		daily := map[string]int{}
+		for _, p := range progress {
+			if p.Day < g.Start || g.Story != "" && p.Story != g.Story {
+				continue
+			}
+			daily[p.Day] += p.Words
+			if g.Story == "" && (g.Deadline == "" || p.Day <= g.Deadline) {
+				gj.Written += p.Words
+			}
+		}
+		if g.Story != "" {
+			s, ok := stories[g.Story]
+			if !ok {
+				continue
+			}
+			gj.Title, gj.Written = s.Title, s.Words
+		}
+
+		gj.Rate = rate(daily, g.Start, today)
+		project(&gj, today)
+		out.Goals = append(out.Goals, gj)
+	}
+	sort.Sort(byDeadline(out.Goals))
+	return out
+}
+
+// rate returns the average words written per day over the last rateDays
+// days, not counting days before start.
+func rate(daily map[string]int, start, today string) float64 {
+	n := rateDays
+	if since := daysBetween(start, today) + 1; since < n {
+		n = since
+	}
+	if n < 1 {
+		return 0
+	}
+	sum := 0
+	for i := 0; i < n; i++ {
+		sum += daily[addDays(today, -i)]
+	}
+	return float64(sum) / float64(n)
+}
+
+// project fills in whether a goal is done or on track and when it will be
+// met at its current rate.
+func project(g *goalJSON, today string) {
+	remaining := g.Words - g.Written
+	if remaining <= 0 {
+		g.Done, g.OnTrack = true, true
+		return
+	}
+
+	if g.Deadline != "" {
+		if left := daysBetween(today, g.Deadline) + 1; left > 0 {
+			g.Needed = (remaining + left - 1) / left
+		}
+	}
+	if g.Rate <= 0 {
+		return
+	}
+
+	// Today counts if the rate allows the rest to be written today
+	days := int(math.Ceil(float64(remaining)/g.Rate)) - 1
+	g.Projected = addDays(today, days)
+	g.OnTrack = g.Deadline == "" || g.Projected <= g.Deadline
+}
+
+// byDeadline sorts goals with the nearest deadline first and those without
+// deadlines last.
+type byDeadline []goalJSON
+
+func (b byDeadline) Len() int      { return len(b) }
+func (b byDeadline) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byDeadline) Less(i, j int) bool {
+	if (b[i].Deadline == "") != (b[j].Deadline == "") {
+		return b[j].Deadline == ""
+	}
+	return b[i].Deadline < b[j].Deadline
+}
@@ -0,0 +1,357 @@
+package ui
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"fictex"
+	"fictex/stats"
+)
+
+// A FieldKind describes the values which a metadata field can hold.
+type FieldKind int
+
+const (
+	Free     FieldKind = iota // Any single value
+	Choice                    // One of the field's Choices
+	List                      // A comma-separated list of values
+	Choices                   // A comma-separated list of the field's Choices
+	Computed                  // Filled in when the story is saved
+)
+
+// A Field describes one of the standard headers of a story.
+type Field struct {
+	Name    string // The name of the Property
+	Label   string
+	Kind    FieldKind
+	Choices []string // The allowed values of a Choice or Choices field
+	Default string   // Suggested for new stories
+}
+
+// Multi returns true if the field holds more than one value.
+func (f Field) Multi() bool {
+	return f.Kind == List || f.Kind == Choices
+}
+
+// Schema lists the standard headers in the order in which they are shown.
+// Stories may also have other, free-form headers.
+var Schema = []Field{
+	{Name: "author", Label: "Author"},
+	{Name: "rating", Label: "Rating", Kind: Choice, Default: "PG-13",
+		Choices: []string{"G", "PG", "PG-13", "R", "NC-17"}},
+	{Name: "fandom", Label: "Fandoms", Kind: List, Default: "None"},
+	{Name: "characters", Label: "Characters", Kind: List, Default: "Original"},
+	{Name: "relationships", Label: "Relationships", Kind: List},
+	{Name: "warnings", Label: "Warnings", Kind: Choices,
+		Choices: []string{
+			"None", "Violence", "Major Character Death",
+			"Non-Consent", "Underage", "Author Chose Not To Warn",
+		}},
+	{Name: "tags", Label: "Tags", Kind: List},
+	{Name: "dictionary", Label: "Dictionary", Kind: List},
+	{Name: "language", Label: "Language", Kind: Choice, Default: "English",
+		Choices: []string{"English", "French", "German", "Russian"}},
+	{Name: "typography", Label: "Typography", Kind: Choice, Default: "Plain",
+		Choices: []string{"Plain", "Smart"}},
+	{Name: "words", Label: "Words", Kind: Computed},
+	{Name: "reading", Label: "Reading Time", Kind: Computed},
+	{Name: "created", Label: "Published", Kind: Computed},
+	{Name: "updated", Label: "Updated", Kind: Computed},
+}
+
+// SchemaField returns the standard header with the given name.
+func SchemaField(name string) (Field, bool) {
+	for _, f := range Schema {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+// A ValidationError describes why a header could not be saved.
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e ValidationError) Error() string { return e.Field + ": " + e.Message }
+
+// maxValue is the longest allowed header value.
+const maxValue = 500
+
+var customName = regexp.MustCompile(`^[-_a-z0-9]{1,32}$`)
+
+// reservedNames are the names of the editor's own fields, which headers may
+// not use.  The title is the only field which is also a header.
+var reservedNames = map[string]bool{"source": true, "storyid": true}
+
+// Validate checks the value of the named header and returns the values to
+// store.  Multi-valued headers are split on commas.  Headers which are not
+// in the Schema must have names like those allowed by the editor.
+func Validate(name, value string) ([]string, error) {
+	f, ok := SchemaField(name)
+	if !ok {
+		if !customName.MatchString(name) {
+			return nil, ValidationError{name, "names may only contain letters, numbers, dash, and underscore"}
+		}
+		if reservedNames[name] {
+			return nil, ValidationError{name, "is used by the editor"}
+		}
+		f = Field{Name: name}
+	}
+
+	if len(value) > maxValue {
+		return nil, ValidationError{name, fmt.Sprintf("must be %d characters or fewer", maxValue)}
+	}
+
+	values := []string{strings.TrimSpace(value)}
+	if f.Multi() {
+		values = splitList(value)
+	}
+
+	switch f.Kind {
+	case Computed:
+		return nil, ValidationError{name, "is computed and cannot be set"}
+	case Choice, Choices:
+		for i, v := range values {
+			choice, ok := f.choose(v)
+			if !ok {
+				return nil, ValidationError{name, fmt.Sprintf("%q must be one of %s", v, strings.Join(f.Choices, ", "))}
+			}
+			values[i] = choice
+		}
+	}
+	return values, nil
+}
+
+// choose returns the choice which matches v, ignoring case.
+func (f Field) choose(v string) (string, bool) {
+	for _, c := range f.Choices {
+		if strings.EqualFold(c, v) {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// splitList splits a comma-separated list, dropping empty and repeated values.
+func splitList(value string) []string {
+	var values []string
+	seen := map[string]bool{}
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" || seen[strings.ToLower(v)] {
+			continue
+		}
+		seen[strings.ToLower(v)] = true
+		values = append(values, v)
+	}
+	return values
+}
+
+// A Header is a single line of metadata about a story, ready for display.
+type Header struct {
+	Field
+	Value string
+}
+
+// Headers returns the story's metadata in display order: the headers named
+// in the story's Order, then the remaining Schema fields, then any other
+// headers in alphabetical order.  If all is true, schema fields which are
+// not set are included with their Default values.
+func (s *Story) Headers(all bool) []Header {
+	headers := s.defaultHeaders(all)
+	if len(s.Order) == 0 {
+		return headers
+	}
+
+	ordered := make([]Header, 0, len(headers))
+	used := make([]bool, len(headers))
+	for _, name := range s.Order {
+		for i, h := range headers {
+			if h.Name == name && !used[i] {
+				ordered = append(ordered, h)
+				used[i] = true
+				break
+			}
+		}
+	}
+	for i, h := range headers {
+		if !used[i] {
+			ordered = append(ordered, h)
+		}
+	}
+	return ordered
+}
+
+// ValidOrder returns the names from a header order which could name a
+// header, without repeats.
+func ValidOrder(names []string) []string {
+	var order []string
+	seen := map[string]bool{}
+	for _, name := range names {
+		if _, ok := SchemaField(name); !ok && !customName.MatchString(name) {
+			continue
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		order = append(order, name)
+	}
+	return order
+}
+
+func (s *Story) defaultHeaders(all bool) []Header {
+	return metaHeaders(s.Meta, s.computed, all)
+}
+
+// metaHeaders returns headers in the default order: the Schema fields, with
+// Computed fields given by computed, then any other headers in alphabetical
+// order.
+func metaHeaders(meta map[string]*Property, computed func(name string) string, all bool) []Header {
+	var headers []Header
+	for _, f := range Schema {
+		var value string
+		switch {
+		case f.Kind == Computed:
+			value = computed(f.Name)
+		case meta[f.Name] != nil:
+			value = meta[f.Name].Value
+		case all:
+			value = f.Default
+		}
+		if value == "" && !all {
+			continue
+		}
+		headers = append(headers, Header{f, value})
+	}
+
+	var custom []string
+	for name, prop := range meta {
+		if _, ok := SchemaField(name); ok || len(name) == 0 || prop == nil || len(prop.Name) == 0 {
+			continue
+		}
+		custom = append(custom, name)
+	}
+	sort.Strings(custom)
+
+	for _, name := range custom {
+		prop := meta[name]
+		headers = append(headers, Header{
+			Field: Field{
+				Name:  name,
+				Label: strings.ToUpper(prop.Name[:1]) + prop.Name[1:],
+			},
+			Value: prop.Value,
+		})
+	}
+	return headers
+}
+
+// computed returns the value of a Computed field.
+func (s *Story) computed(name string) string {
+	switch name {
+	case "words":
+		if s.Words > 0 {
+			return strconv.Itoa(s.Words)
+		}
+	case "reading":
+		if s.ReadingTime > 0 {
+			return readingTime(s.ReadingTime)
+		}
+	case "created":
+		if !s.Created.IsZero() {
+			return s.Created.Format("January 2, 2006")
+		}
+	case "updated":
+		if !s.Updated.IsZero() {
+			return s.Updated.Format("January 2, 2006")
+		}
+	}
+	return ""
+}
+
+// languages gives the fictex locale of each choice of the language header.
+var languages = map[string]string{
+	"English": "en",
+	"French":  "fr",
+	"German":  "de",
+	"Russian": "ru",
+}
+
+// Typography returns the typography of the named language, or that of
+// English if the language is not known.
+func Typography(language string) *fictex.Typography {
+	for name, code := range languages {
+		if strings.EqualFold(name, language) {
+			if t, ok := fictex.Locales[code]; ok {
+				return t
+			}
+		}
+	}
+	return &fictex.English
+}
+
+// Typography returns the typography of the story's language.
+func (s *Story) Typography() *fictex.Typography {
+	if prop := s.Meta["language"]; prop != nil {
+		return Typography(prop.Value)
+	}
+	return Typography("")
+}
+
+// SmartTypography reports whether the story's Typography header asks for
+// its quotes, apostrophes, and ellipses to be typeset when it is read.
+func (s *Story) SmartTypography() bool {
+	prop := s.Meta["typography"]
+	return prop != nil && prop.Value == "Smart"
+}
+
+// Statistics returns the statistics of a fictex document, not counting any
+// markup.  A document which cannot be parsed has none.
+func Statistics(source []byte) stats.Stats {
+	node, _, err := fictex.ParseDocumentBytes(source)
+	if err != nil {
+		return stats.Stats{}
+	}
+	return stats.Count(node)
+}
+
+// readingTime formats a reading time in whole minutes.
+func readingTime(d time.Duration) string {
+	minutes := int((d + time.Minute/2) / time.Minute)
+	if minutes < 1 {
+		return "under a minute"
+	}
+	if minutes == 1 {
+		return "1 minute"
+	}
+	return fmt.Sprintf("%d minutes", minutes)
+}
+
+// statsJSON is the JSON encoding of the statistics of a story or chapter.
+type statsJSON struct {
+	Title       string  `json:"title,omitempty"`
+	Words       int     `json:"words"`
+	Characters  int     `json:"characters"`
+	Paragraphs  int     `json:"paragraphs"`
+	Dialogue    float64 `json:"dialogue"`    // The fraction of words which are dialogue
+	ReadingTime int     `json:"readingTime"` // In seconds
+}
+
+func toStatsJSON(title string, s stats.Stats) statsJSON {
+	return statsJSON{
+		Title:       title,
+		Words:       s.Words,
+		Characters:  s.Characters,
+		Paragraphs:  s.Paragraphs,
+		Dialogue:    s.DialogueRatio(),
+		ReadingTime: int(s.ReadingTime / time.Second),
+	}
+}
@@ -0,0 +1,651 @@
+package ui
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+	"fictex"
+)
+
+// Set up the handlers
+
+func init() {
+	http.Handle("/series", Wrapper(SeriesPage))
+	http.Handle("/series/data", Wrapper(SeriesData))
+	http.Handle("/export/series/", Wrapper(ExportSeriesPage))
+}
+
+// A Series is an ordered collection of a user's stories, such as a story
+// and its sequels.  A story is part of at most one series.
+type Series struct {
+	key *datastore.Key
+
+	ID      string
+	Title   string
+	Summary string               // Fictex source
+	Stories []string             // The IDs of the stories in reading order
+	Meta    map[string]*Property `datastore:"-"`
+	Created time.Time
+
+	// Copies of each story's Title and Slug and whether it is in the trash,
+	// so that a story can link to the others without loading them
+	Titles  []string
+	Slugs   []string
+	Trashed []bool
+}
+
+// A SeriesPart is what a series keeps of one of its stories.
+type SeriesPart struct {
+	ID, Title, Slug string
+	Trashed         bool
+}
+
+// partOf returns what a series keeps of a story.
+func partOf(s *Story) SeriesPart {
+	return SeriesPart{s.ID, s.Title, s.Slug, !s.Deleted.IsZero()}
+}
+
+// URL returns the path at which the part is read.
+func (p SeriesPart) URL() string {
+	if p.Slug != "" {
+		return readURL(p.Slug)
+	}
+	return readURL(p.ID)
+}
+
+// Parts returns what the series keeps of each of its stories, in order.  A
+// series saved before the copies were kept has only the IDs until it is
+// changed or BackfillSeries is run; see fill.
+func (se *Series) Parts() []SeriesPart {
+	parts := make([]SeriesPart, len(se.Stories))
+	for i, id := range se.Stories {
+		parts[i].ID = id
+		if se.complete() {
+			parts[i].Title, parts[i].Slug, parts[i].Trashed = se.Titles[i], se.Slugs[i], se.Trashed[i]
+		}
+	}
+	return parts
+}
+
+// LiveParts returns what the series keeps of each of its stories which is
+// not in the trash, in order.  If the series does not have the copies, its
+// stories are loaded to make them, but they are not saved.
+func (se *Series) LiveParts(c appengine.Context) ([]SeriesPart, error) {
+	if err := se.fill(c); err != nil {
+		return nil, err
+	}
+	var parts []SeriesPart
+	for _, p := range se.Parts() {
+		if !p.Trashed {
+			parts = append(parts, p)
+		}
+	}
+	return parts, nil
+}
+
+// setParts replaces the stories of the series and the copies kept of them.
+func (se *Series) setParts(parts []SeriesPart) {
+	se.Stories, se.Titles, se.Slugs, se.Trashed = nil, nil, nil, nil
+	for _, p := range parts {
+		se.Stories = append(se.Stories, p.ID)
+		se.Titles = append(se.Titles, p.Title)
+		se.Slugs = append(se.Slugs, p.Slug)
+		se.Trashed = append(se.Trashed, p.Trashed)
+	}
+}
+
+// complete reports whether the series has a copy of each of its stories.
+func (se *Series) complete() bool {
+	return len(se.Titles) == len(se.Stories) && len(se.Slugs) == len(se.Stories) &&
+		len(se.Trashed) == len(se.Stories)
+}
+
+// fill loads the stories of a series which does not have a copy of each of
+// them, as one saved before the copies were kept, to make the copies.
+func (se *Series) fill(c appengine.Context) error {
+	if se.complete() {
+		return nil
+	}
+	parts := se.Parts()
+	for i := range parts {
+		s := NewStory(c, parts[i].ID, se.key.Parent())
+		switch err := datastore.Get(c, s.key, s); err {
+		case nil:
+			parts[i] = partOf(s)
+		case datastore.ErrNoSuchEntity:
+			parts[i].Trashed = true
+		default:
+			return err
+		}
+	}
+	se.setParts(parts)
+	return nil
+}
+
+func NewSeries(c appengine.Context, id string, owner *datastore.Key) *Series {
+	return &Series{
+		key:  datastore.NewKey(c, "Series", id, 0, owner),
+		ID:   id,
+		Meta: make(map[string]*Property),
+	}
+}
+
+// Get loads the series and its metadata.
+func (se *Series) Get(c appengine.Context) error {
+	return datastore.RunInTransaction(c, func(tx appengine.Context) error {
+		// The datastore appends to slices, so a series is loaded afresh
+		*se = Series{key: se.key, ID: se.ID}
+		if err := datastore.Get(tx, se.key, se); err != nil {
+			return err
+		}
+
+		meta, err := getProperties(tx, se.key)
+		if err != nil {
+			return err
+		}
+		se.Meta = meta
+
+		return nil
+	}, nil)
+}
+
+// Put saves a new series.  Its metadata is replaced by Meta.  A series
+// which already exists is changed with update, so that changes to its
+// stories made at the same time are not lost.
+func (se *Series) Put(c appengine.Context) error {
+	if se.Created.IsZero() {
+		se.Created = time.Now()
+	}
+
+	return datastore.RunInTransaction(c, func(tx appengine.Context) error {
+		if _, err := datastore.Put(tx, se.key, se); err != nil {
+			return err
+		}
+		return se.putMeta(tx)
+	}, nil)
+}
+
+// putMeta replaces the metadata of the series in the datastore with Meta.
+// It must be called in a transaction.
+func (se *Series) putMeta(tx appengine.Context) error {
+	q := datastore.NewQuery("Property")
+	q.Ancestor(se.key)
+	q.KeysOnly()
+
+	keys, err := q.GetAll(tx, nil)
+	if err != nil {
+		return err
+	}
+	var removed []*datastore.Key
+	for _, key := range keys {
+		if se.Meta[key.StringID()] == nil {
+			removed = append(removed, key)
+		}
+	}
+	if err := datastore.DeleteMulti(tx, removed); err != nil {
+		return err
+	}
+
+	for _, prop := range se.Meta {
+		if err := prop.Put(tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete deletes the series and its metadata, but not its stories.
+func (se *Series) Delete(c appengine.Context) error {
+	q := datastore.NewQuery("Property")
+	q.Ancestor(se.key)
+	q.KeysOnly()
+
+	return datastore.RunInTransaction(c, func(tx appengine.Context) error {
+		keys, err := q.GetAll(tx, nil)
+		if err != nil {
+			return err
+		}
+		if err := datastore.DeleteMulti(tx, keys); err != nil {
+			return err
+		}
+		return datastore.Delete(tx, se.key)
+	}, nil)
+}
+
+// update applies f to the copy of the series in the datastore and saves it.
+// The series is given to f without its metadata, which is only replaced if
+// f sets Meta.  The copies of its stories are made first if it does not
+// have them.
+func (se *Series) update(c appengine.Context, f func(*Series) error) error {
+	return datastore.RunInTransaction(c, func(tx appengine.Context) error {
+		// The datastore appends to slices, so the series is loaded afresh
+		// rather than into se, which may be loaded already or be left from
+		// an attempt which is being retried
+		loaded := &Series{key: se.key, ID: se.ID}
+		err := datastore.Get(tx, loaded.key, loaded)
+		if err == datastore.ErrNoSuchEntity {
+			return NotFound(se.ID)
+		}
+		if err != nil {
+			return err
+		}
+		if err := loaded.fill(tx); err != nil {
+			return err
+		}
+
+		if err := f(loaded); err != nil {
+			return err
+		}
+
+		if _, err := datastore.Put(tx, loaded.key, loaded); err != nil {
+			return err
+		}
+		if loaded.Meta != nil {
+			if err := loaded.putMeta(tx); err != nil {
+				return err
+			}
+		} else {
+			loaded.Meta = se.Meta
+		}
+		*se = *loaded
+		return nil
+	}, nil)
+}
+
+// Headers returns the series' metadata in display order.  A series has no
+// computed headers.
+func (se *Series) Headers() []Header {
+	return metaHeaders(se.Meta, func(string) string { return "" }, false)
+}
+
+// Typography returns the typography of the series' language, or of its
+// first story's if it has none.
+func (se *Series) Typography(stories []*Story) *fictex.Typography {
+	if prop := se.Meta["language"]; prop != nil {
+		return Typography(prop.Value)
+	}
+	if len(stories) == 0 {
+		return Typography("")
+	}
+	return stories[0].Typography()
+}
+
+// LoadStories returns the stories of the series in order, leaving out any
+// which are in the trash or no longer exist.
+func (se *Series) LoadStories(c appengine.Context) ([]*Story, error) {
+	var stories []*Story
+	for _, id := range se.Stories {
+		s := NewStory(c, id, se.key.Parent())
+		switch err := s.Get(c); err {
+		case nil:
+		case datastore.ErrNoSuchEntity:
+			continue
+		default:
+			return nil, err
+		}
+		if s.Deleted.IsZero() {
+			stories = append(stories, s)
+		}
+	}
+	return stories, nil
+}
+
+// seriesKeys returns the keys of the series which include a user's story.
+func seriesKeys(c appengine.Context, user *datastore.Key, story string) ([]*datastore.Key, error) {
+	q := datastore.NewQuery("Series")
+	q.Ancestor(user)
+	q.Filter("Stories =", story)
+	q.KeysOnly()
+	return q.GetAll(c, nil)
+}
+
+// SeriesOf returns the series which includes a user's story, or nil if
+// there is none.
+func SeriesOf(c appengine.Context, user *datastore.Key, story string) (*Series, error) {
+	keys, err := seriesKeys(c, user, story)
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	se := NewSeries(c, keys[0].StringID(), user)
+	if err := se.Get(c); err != nil {
+		return nil, err
+	}
+	return se, nil
+}
+
+// updateSeries applies f to the part for a story in any series which
+// includes it.
+func updateSeries(c appengine.Context, user *datastore.Key, story string, f func([]SeriesPart, int) []SeriesPart) error {
+	keys, err := seriesKeys(c, user, story)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		err := NewSeries(c, key.StringID(), user).update(c, func(se *Series) error {
+			parts := se.Parts()
+			for i := range parts {
+				if parts[i].ID == story {
+					parts = f(parts, i)
+					break
+				}
+			}
+			se.setParts(parts)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeFromSeries removes a story from any series which includes it.
+func removeFromSeries(c appengine.Context, user *datastore.Key, story string) error {
+	return updateSeries(c, user, story, func(parts []SeriesPart, i int) []SeriesPart {
+		return append(parts[:i], parts[i+1:]...)
+	})
+}
+
+// refreshSeries updates the copy of a story kept by any series which
+// includes it, after it is renamed or moved into or out of the trash.
+func refreshSeries(c appengine.Context, s *Story) error {
+	return updateSeries(c, s.key.Parent(), s.ID, func(parts []SeriesPart, i int) []SeriesPart {
+		parts[i] = partOf(s)
+		return parts
+	})
+}
+
+// parseHeaders reads the metadata of a series written as headers are at
+// the top of a story, one "Key: value" per line.
+func parseHeaders(c appengine.Context, se *Series, text string) error {
+	// Any key is allowed in a block which ends with ---
+	_, fm, err := fictex.ParseDocument(strings.NewReader(strings.TrimSpace(text) + "\n---\n"))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(text) != "" && len(fm.Keys) == 0 {
+		return BadRequest(`headers must be written as "Key: value"`)
+	}
+
+	meta := make(map[string]*Property)
+	for _, key := range fm.Keys {
+		values, err := Validate(key, fm.Values[key])
+		if err != nil {
+			return BadRequest(err.Error())
+		}
+		p := newProperty(c, se.key, key, strings.Join(values, ", "))
+		p.Values = values
+		meta[key] = p
+	}
+	se.Meta = meta
+	return nil
+}
+
+// SeriesPage lists the user's series.
+func SeriesPage(c appengine.Context, w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/xhtml+xml; charset=UTF-8")
+
+	_, k := UserKey(c)
+	js, err := JSONStoryList(c, k)
+	if err != nil {
+		return err
+	}
+	return templates.ExecuteTemplate(w, "series.html", map[string]interface{}{
+		"Stories": string(js),
+	})
+}
+
+// SeriesData creates, changes, or deletes one of the user's series, or
+// adds, moves, or removes one of its stories, and responds with all of the
+// user's series.
+func SeriesData(c appengine.Context, w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	_, k := UserKey(c)
+	id, story := r.Form.Get("id"), r.Form.Get("story")
+
+	switch action := r.Form.Get("action"); action {
+	case "":
+	case "create":
+		title := strings.TrimSpace(r.Form.Get("title"))
+		if title == "" {
+			return BadRequest("title must not be empty")
+		}
+		se := NewSeries(c, GenID(title), k)
+		se.Title = title
+		if err := se.Put(c); err != nil {
+			return err
+		}
+		c.Infof("Creating a new series: %q as %s", title, se.ID)
+	case "update":
+		edited := NewSeries(c, id, k)
+		if err := parseHeaders(c, edited, r.Form.Get("headers")); err != nil {
+			return err
+		}
+		title := strings.TrimSpace(r.Form.Get("title"))
+		err := edited.update(c, func(se *Series) error {
+			if title != "" {
+				se.Title = title
+			}
+			se.Summary = strings.TrimSpace(r.Form.Get("summary"))
+			se.Meta = edited.Meta
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	case "delete":
+		if err := NewSeries(c, id, k).Delete(c); err != nil {
+			return err
+		}
+		c.Infof("Series %s: deleted", id)
+	case "add":
+		s := NewStory(c, story, k)
+		if err := datastore.Get(c, s.key, s); err != nil {
+			return NotFound(story)
+		}
+		other, err := SeriesOf(c, k, story)
+		if err != nil {
+			return err
+		}
+		if other != nil {
+			return BadRequest("the story is already part of " + other.Title)
+		}
+		err = NewSeries(c, id, k).update(c, func(se *Series) error {
+			se.setParts(append(se.Parts(), partOf(s)))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	case "remove":
+		if err := removeFromSeries(c, k, story); err != nil {
+			return err
+		}
+	case "move":
+		// Moves a story up (-1) or down (1) in the series
+		by := 1
+		if r.Form.Get("by") == "-1" {
+			by = -1
+		}
+		err := NewSeries(c, id, k).update(c, func(se *Series) error {
+			parts := se.Parts()
+			for i, p := range parts {
+				if p.ID == story && i+by >= 0 && i+by < len(parts) {
+					parts[i], parts[i+by] = parts[i+by], parts[i]
+					break
+				}
+			}
+			se.setParts(parts)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	default:
+		return NotFound(action)
+	}
+
+	return writeSeries(c, w, k)
+}
+
+// seriesJSON is the JSON encoding of a series and its stories.
+type seriesJSON struct {
+	Id      string           `json:"id"`
+	Title   string           `json:"title"`
+	Summary string           `json:"summary"`
+	Headers string           `json:"headers"` // As "Key: value" lines
+	Stories []seriesPartJSON `json:"stories"`
+}
+
+type seriesPartJSON struct {
+	Id   string `json:"id"`
+	Slug string `json:"slug,omitempty"`
+	Name string `json:"name"`
+}
+
+func writeSeries(c appengine.Context, w http.ResponseWriter, user *datastore.Key) error {
+	q := datastore.NewQuery("Series")
+	q.Ancestor(user)
+	q.Order("Created")
+	q.KeysOnly()
+
+	keys, err := q.GetAll(c, nil)
+	if err != nil {
+		return err
+	}
+
+	out := []seriesJSON{}
+	for _, key := range keys {
+		se := NewSeries(c, key.StringID(), user)
+		if err := se.Get(c); err != nil {
+			return err
+		}
+		parts, err := se.LiveParts(c)
+		if err != nil {
+			return err
+		}
+
+		var headers []string
+		for _, h := range se.Headers() {
+			headers = append(headers, h.Name+": "+h.Value)
+		}
+
+		sj := seriesJSON{
+			Id:      se.ID,
+			Title:   se.Title,
+			Summary: se.Summary,
+			Headers: strings.Join(headers, "\n"),
+			Stories: []seriesPartJSON{},
+		}
+		for _, p := range parts {
+			sj.Stories = append(sj.Stories, seriesPartJSON{p.ID, p.Slug, p.Title})
+		}
+		out = append(out, sj)
+	}
+
+	encoded, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if _, err := w.Write(encoded); err != nil {
+		return err
+	}
+	return nil
+}
+
+// demote moves the headings of a document down a level so that it can be
+// part of a larger one.  Headings at MaxLevel stay there.
+func demote(n *fictex.Node) {
+	if n.Type == fictex.Heading && n.Level < fictex.MaxLevel {
+		n.Level++
+	}
+	for i := range n.Child {
+		demote(&n.Child[i])
+	}
+}
+
+// seriesNode returns the series as a single document: its title, headers,
+// and summary, followed by each story under a heading with its title.
+// Headings in the stories are moved down a level.
+func seriesNode(se *Series, stories []*Story) (fictex.Node, error) {
+	n := headerNode(se.Title, se.Headers())
+	if se.Summary != "" {
+		summary, err := fictex.ParseString(se.Summary)
+		if err != nil {
+			return n, err
+		}
+		n.Child = append(n.Child, summary.Child...)
+		n.Child = append(n.Child, fictex.Node{Type: fictex.HLine})
+	}
+
+	for _, s := range stories {
+		node, _, err := fictex.ParseDocumentBytes(s.Source)
+		if err != nil {
+			return n, err
+		}
+		demote(&node)
+
+		n.Child = append(n.Child, fictex.Node{
+			Type:  fictex.Heading,
+			Level: 1,
+			Child: []fictex.Node{{Type: fictex.Text, Text: []byte(s.Title)}},
+		})
+		n.Child = append(n.Child, headerNode("", s.Headers(false)).Child...)
+		if node.Type == fictex.Group {
+			n.Child = append(n.Child, node.Child...)
+		} else {
+			n.Child = append(n.Child, node)
+		}
+	}
+	return n, nil
+}
+
+// ExportSeries renders a series and its stories as one document.
+func ExportSeries(w io.Writer, se *Series, stories []*Story, r fictex.Renderer) error {
+	n, err := seriesNode(se, stories)
+	if err != nil {
+		return err
+	}
+	return r.Render(w, n)
+}
+
+// ExportSeriesPage sends a series and all of its stories in the requested
+// format as plain text suitable for copying elsewhere.
+func ExportSeriesPage(c appengine.Context, w http.ResponseWriter, r *http.Request) error {
+	id := r.URL.Path[len("/export/series/"):]
+
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	renderer := fictex.TextRenderer
+	if r, ok := Renderers[r.Form.Get("format")]; ok {
+		renderer = r
+	}
+
+	_, k := UserKey(c)
+	se := NewSeries(c, id, k)
+	if err := se.Get(c); err != nil {
+		return NotFound(r.URL.Path)
+	}
+	stories, err := se.LoadStories(c)
+	if err != nil {
+		return err
+	}
+	if r.Form.Get("typography") != "" {
+		renderer.Typography = se.Typography(stories)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	return ExportSeries(w, se, stories, renderer)
+}
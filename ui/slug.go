@@ -0,0 +1,175 @@
+package ui
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"appengine"
+	"appengine/datastore"
+)
+
+// A Slug maps a human-readable name in a /read/ URL to a story.  Slugs are
+// never deleted while their story exists, so that links made before the
+// story was renamed continue to work.
+type Slug struct {
+	key *datastore.Key
+
+	Story string // The ID of the story
+}
+
+// maxSlug is the longest slug derived from a title, not counting any suffix
+// added to make it unique.
+const maxSlug = 60
+
+// maxSuffix is the largest numeric suffix tried when claiming a slug.
+const maxSuffix = 100
+
+// Slugify derives a slug from a title: letters and digits in any script are
+// lowercased and everything else is collapsed into single dashes, so that
+// "Война и мир" becomes "война-и-мир".  Slugs are percent-encoded in URLs
+// (see readURL).
+func Slugify(title string) string {
+	var slug []rune
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		// Combining accents stay with their letters
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || (unicode.IsMark(r) && len(slug) > 0 && !dash) {
+			if dash && len(slug) > 0 {
+				slug = append(slug, '-')
+			}
+			slug = append(slug, r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	if len(slug) > maxSlug {
+		slug = []rune(strings.TrimRight(string(slug[:maxSlug]), "-"))
+	}
+
+	// Slugs may not be mistaken for IDs
+	if isID(string(slug)) {
+		slug = append(slug, []rune("-fic")...)
+	}
+	return string(slug)
+}
+
+// readURL returns the path at which the story with the given slug or ID is
+// read, with any letters outside ASCII percent-encoded.
+func readURL(slug string) string {
+	u := url.URL{Path: "/read/" + slug}
+	return u.String()
+}
+
+// isID returns true if id looks like an ID generated by GenID.
+func isID(id string) bool {
+	if len(id) != 40 {
+		return false
+	}
+	for _, r := range id {
+		if !unicode.Is(unicode.ASCII_Hex_Digit, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasBase returns true if slug is base or base followed by a numeric suffix.
+func hasBase(slug, base string) bool {
+	if !strings.HasPrefix(slug, base) {
+		return false
+	}
+	suffix := slug[len(base):]
+	if suffix == "" {
+		return true
+	}
+	if len(suffix) < 2 || suffix[0] != '-' {
+		return false
+	}
+	for _, r := range suffix[1:] {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// ClaimSlug gives the story a slug derived from its title if its current
+// slug does not match.  If another story already has the slug, a numeric
+// suffix is added.  The old slug continues to refer to the story.
+func (s *Story) ClaimSlug(c appengine.Context) error {
+	base := Slugify(s.Title)
+	if base == "" || (s.Slug != "" && hasBase(s.Slug, base)) {
+		return nil
+	}
+
+	for n := 1; n <= maxSuffix; n++ {
+		slug := base
+		if n > 1 {
+			slug = fmt.Sprintf("%s-%d", base, n)
+		}
+
+		claimed := false
+		err := datastore.RunInTransaction(c, func(tx appengine.Context) error {
+			claimed = false
+			key := datastore.NewKey(tx, "Slug", slug, 0, nil)
+
+			current := new(Slug)
+			switch err := datastore.Get(tx, key, current); err {
+			case nil:
+				if current.Story != s.ID {
+					return nil
+				}
+			case datastore.ErrNoSuchEntity:
+			default:
+				return err
+			}
+
+			if _, err := datastore.Put(tx, key, &Slug{Story: s.ID}); err != nil {
+				return err
+			}
+			claimed = true
+			return nil
+		}, nil)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+
+		c.Infof("Story %s is now %q", s.ID, slug)
+		return s.update(c, func(s *Story) {
+			s.Slug = slug
+		})
+	}
+	return fmt.Errorf("%s: no free slug for %q", s.ID, base)
+}
+
+// deleteSlugs deletes all slugs which refer to the story with the given ID.
+func deleteSlugs(c appengine.Context, id string) error {
+	q := datastore.NewQuery("Slug")
+	q.Filter("Story =", id)
+	q.KeysOnly()
+
+	keys, err := q.GetAll(c, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(c, keys)
+}
+
+// storyForSlug returns the ID of the story to which slug refers.
+func storyForSlug(c appengine.Context, slug string) (string, error) {
+	sl := new(Slug)
+	err := datastore.Get(c, datastore.NewKey(c, "Slug", slug, 0, nil), sl)
+	if err == datastore.ErrNoSuchEntity {
+		return "", NotFound(slug)
+	}
+	if err != nil {
+		return "", err
+	}
+	return sl.Story, nil
+}
@@ -9,6 +9,7 @@ import (
 
 	"appengine"
 	"appengine/datastore"
+	"fictex/stats"
 )
 
 func GenID(seed string) string {
@@ -18,14 +19,34 @@ func GenID(seed string) string {
 }
 
 type Story struct {
-	key *datastore.Key
+	key     *datastore.Key
+	renamed bool // Set by Put when the title changes
+	added   int  // Set by Put to the change in Words
+
+	ID      string
+	Slug    string // The current name of the story in /read/ URLs
+	Title   string
+	Source  []byte
+	Version int64                // Incremented every time the story is saved
+	Meta    map[string]*Property `datastore:"-"`
+	Order   []string             // The names of the headers in display order
 
-	ID     string
-	Title  string
-	Source []byte
-	Meta   map[string]*Property `datastore:"-"`
+	Archived bool      // Hidden from the story list
+	Deleted  time.Time // When the story was moved to the trash, if it was
+
+	// Computed when the story is saved
+	Words       int
+	Characters  int
+	Paragraphs  int
+	Dialogue    int // Words of dialogue
+	ReadingTime time.Duration
+	Created     time.Time
+	Updated     time.Time
 }
 
+// TrashRetention is how long a story stays in the trash before it is purged.
+const TrashRetention = 30 * 24 * time.Hour
+
 func NewStory(c appengine.Context, id string, owner *datastore.Key) *Story {
 	return &Story{
 		key:  datastore.NewKey(c, "Story", id, 0, owner),
@@ -34,8 +55,50 @@ func NewStory(c appengine.Context, id string, owner *datastore.Key) *Story {
 	}
 }
 
+// Put saves the story if the copy in the datastore is still at the Version
+// it was loaded from and increments the Version.  If the story has been
+// saved since, Put returns a Conflict.  The story's Slug and whether it is
+// archived or in the trash are preserved, as are its Title and Order if
+// they are empty.
+// Put also records when the story was created and updated, and how many
+// words were added.  Its metadata is replaced by Meta, except that the stored
+// value of a header whose entry in Meta is nil is kept.
 func (s *Story) Put(c appengine.Context) error {
-	return datastore.RunInTransaction(c, func(tx appengine.Context) error {
+	q := datastore.NewQuery("Property")
+	q.Ancestor(s.key)
+	q.KeysOnly()
+
+	version := s.Version
+	err := datastore.RunInTransaction(c, func(tx appengine.Context) error {
+		current := new(Story)
+		switch err := datastore.Get(tx, s.key, current); err {
+		case nil:
+			if current.Version != version {
+				return Conflict(s.ID)
+			}
+			if s.Title == "" {
+				s.Title = current.Title
+			}
+			if len(s.Order) == 0 {
+				s.Order = current.Order
+			}
+			s.renamed = s.Title != current.Title
+			s.added = s.Words - current.Words
+			s.Slug = current.Slug
+			s.Archived, s.Deleted = current.Archived, current.Deleted
+			s.Created = current.Created
+		case datastore.ErrNoSuchEntity:
+			s.added = s.Words
+		default:
+			return err
+		}
+
+		s.Updated = time.Now()
+		if s.Created.IsZero() {
+			s.Created = s.Updated
+		}
+
+		s.Version = version + 1
 		key, err := datastore.Put(tx, s.key, s)
 		if err != nil {
 			return err
@@ -43,7 +106,24 @@ func (s *Story) Put(c appengine.Context) error {
 
 		s.key = key
 
+		keys, err := q.GetAll(tx, nil)
+		if err != nil {
+			return err
+		}
+		var removed []*datastore.Key
+		for _, key := range keys {
+			if _, ok := s.Meta[key.StringID()]; !ok {
+				removed = append(removed, key)
+			}
+		}
+		if err := datastore.DeleteMulti(tx, removed); err != nil {
+			return err
+		}
+
 		for _, prop := range s.Meta {
+			if prop == nil {
+				continue
+			}
 			if err := prop.Put(tx); err != nil {
 				return err
 			}
@@ -51,37 +131,134 @@ func (s *Story) Put(c appengine.Context) error {
 
 		return nil
 	}, nil)
+	if err != nil {
+		s.Version = version
+	}
+	return err
 }
 
-func (s *Story) Get(c appengine.Context) error {
-	// Construct the query once
-	q := datastore.NewQuery("Property")
-	q.Ancestor(s.key)
+// SetStats records the statistics of the story's source.
+func (s *Story) SetStats(st stats.Stats) {
+	s.Words, s.Characters, s.Paragraphs = st.Words, st.Characters, st.Paragraphs
+	s.Dialogue, s.ReadingTime = st.Dialogue, st.ReadingTime
+}
 
+// Renamed returns true if the last Put changed the title of the story.
+func (s *Story) Renamed() bool {
+	return s.renamed
+}
+
+// Added returns the number of words the last Put added to the story, which
+// is negative if more were removed.
+func (s *Story) Added() int {
+	return s.added
+}
+
+// update applies f to the copy of the story in the datastore and saves it
+// without changing its Version or metadata.
+func (s *Story) update(c appengine.Context, f func(*Story)) error {
 	return datastore.RunInTransaction(c, func(tx appengine.Context) error {
-		if err := datastore.Get(tx, s.key, s); err != nil {
+		err := datastore.Get(tx, s.key, s)
+		if err == datastore.ErrNoSuchEntity {
+			return NotFound(s.ID)
+		}
+		if err != nil {
 			return err
 		}
 
-		props := []*Property{}
-		keys, err := q.GetAll(tx, &props)
-		if err != nil {
+		f(s)
+
+		_, err = datastore.Put(tx, s.key, s)
+		return err
+	}, nil)
+}
+
+// Trash moves the story into the trash, from which it can be restored until
+// it is purged.
+func (s *Story) Trash(c appengine.Context) error {
+	err := s.update(c, func(s *Story) {
+		s.Deleted = time.Now()
+	})
+	if err != nil {
+		return err
+	}
+	return refreshSeries(c, s)
+}
+
+// Restore moves the story out of the trash.
+func (s *Story) Restore(c appengine.Context) error {
+	err := s.update(c, func(s *Story) {
+		s.Deleted = time.Time{}
+	})
+	if err != nil {
+		return err
+	}
+	return refreshSeries(c, s)
+}
+
+// Archive hides or unhides the story in the story list.
+func (s *Story) Archive(c appengine.Context, archived bool) error {
+	return s.update(c, func(s *Story) {
+		s.Archived = archived
+	})
+}
+
+// Delete permanently deletes the story, its metadata and glossary, and its
+// slugs.
+func (s *Story) Delete(c appengine.Context) error {
+	var queries []*datastore.Query
+	for _, kind := range []string{"Property", "GlossaryEntry"} {
+		q := datastore.NewQuery(kind)
+		q.Ancestor(s.key)
+		q.KeysOnly()
+		queries = append(queries, q)
+	}
+
+	err := datastore.RunInTransaction(c, func(tx appengine.Context) error {
+		for _, q := range queries {
+			keys, err := q.GetAll(tx, nil)
+			if err != nil {
+				return err
+			}
+			if err := datastore.DeleteMulti(tx, keys); err != nil {
+				return err
+			}
+		}
+		return datastore.Delete(tx, s.key)
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if err := removeFromSeries(c, s.key.Parent(), s.key.StringID()); err != nil {
+		return err
+	}
+	return deleteSlugs(c, s.key.StringID())
+}
+
+func (s *Story) Get(c appengine.Context) error {
+	return datastore.RunInTransaction(c, func(tx appengine.Context) error {
+		if err := datastore.Get(tx, s.key, s); err != nil {
 			return err
 		}
 
-		s.Meta = make(map[string]*Property)
-		for i, prop := range props {
-			prop.key = keys[i]
-			s.Meta[prop.Name] = prop
+		meta, err := getProperties(tx, s.key)
+		if err != nil {
+			return err
 		}
+		s.Meta = meta
 
 		return nil
 	}, nil)
 }
 
+// GetStory returns the story with the given ID or slug.  The caller can
+// compare the story's Slug to detect a slug from before it was renamed.
 func GetStory(c appengine.Context, id string) (*Story, error) {
-	if len(id) != 40 {
-		return nil, errors.New("invalid story id")
+	if !isID(id) {
+		var err error
+		if id, err = storyForSlug(c, id); err != nil {
+			return nil, err
+		}
 	}
 
 	q := datastore.NewQuery("Story")
@@ -97,13 +274,36 @@ func GetStory(c appengine.Context, id string) (*Story, error) {
 	}
 
 	s := &Story{key: keys[0]}
-	return s, s.Get(c)
+	if err := s.Get(c); err != nil {
+		return nil, err
+	}
+	if !s.Deleted.IsZero() {
+		return nil, NotFound(id)
+	}
+	return s, nil
 }
 
+// JSONStoryList lists the stories which are neither archived nor in the trash.
 func JSONStoryList(c appengine.Context, user *datastore.Key) ([]byte, error) {
+	return jsonStories(c, user, func(s *Story) bool {
+		return !s.Archived && s.Deleted.IsZero()
+	})
+}
+
+// JSONArchiveList lists the stories which are archived or in the trash.
+func JSONArchiveList(c appengine.Context, user *datastore.Key) ([]byte, error) {
+	return jsonStories(c, user, func(s *Story) bool {
+		return s.Archived || !s.Deleted.IsZero()
+	})
+}
+
+func jsonStories(c appengine.Context, user *datastore.Key, show func(*Story) bool) ([]byte, error) {
 	type storydata struct {
-		Id   string `json:"id"`
-		Name string `json:"name"`
+		Id       string `json:"id"`
+		Slug     string `json:"slug,omitempty"`
+		Name     string `json:"name"`
+		Archived bool   `json:"archived,omitempty"`
+		Deleted  bool   `json:"deleted,omitempty"`
 	}
 	var stories []storydata
 
@@ -120,12 +320,15 @@ func JSONStoryList(c appengine.Context, user *datastore.Key) ([]byte, error) {
 		if err != nil {
 			return nil, err
 		}
-		if s.Title == "" {
+		if s.Title == "" || !show(s) {
 			continue
 		}
 		stories = append(stories, storydata{
-			Id:   key.StringID(),
-			Name: s.Title,
+			Id:       key.StringID(),
+			Slug:     s.Slug,
+			Name:     s.Title,
+			Archived: s.Archived,
+			Deleted:  !s.Deleted.IsZero(),
 		})
 	}
 
@@ -135,18 +338,43 @@ func JSONStoryList(c appengine.Context, user *datastore.Key) ([]byte, error) {
 type Property struct {
 	key *datastore.Key
 
-	Name  string
-	Value string
+	Name   string
+	Value  string
+	Values []string // The separate values of a multi-valued header
 }
 
 func (s *Story) NewProperty(c appengine.Context, name, value string) *Property {
-	p := &Property{
-		key:   datastore.NewKey(c, "Property", name, 0, s.key),
+	p := newProperty(c, s.key, name, value)
+	s.Meta[name] = p
+	return p
+}
+
+// newProperty returns a property stored under a story or series.
+func newProperty(c appengine.Context, parent *datastore.Key, name, value string) *Property {
+	return &Property{
+		key:   datastore.NewKey(c, "Property", name, 0, parent),
 		Name:  name,
 		Value: value,
 	}
-	s.Meta[name] = p
-	return p
+}
+
+// getProperties returns the properties stored under a story or series.
+func getProperties(c appengine.Context, parent *datastore.Key) (map[string]*Property, error) {
+	q := datastore.NewQuery("Property")
+	q.Ancestor(parent)
+
+	props := []*Property{}
+	keys, err := q.GetAll(c, &props)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := make(map[string]*Property)
+	for i, prop := range props {
+		prop.key = keys[i]
+		meta[prop.Name] = prop
+	}
+	return meta, nil
 }
 
 func (p *Property) Put(c appengine.Context) error {
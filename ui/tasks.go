@@ -0,0 +1,112 @@
+package ui
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+)
+
+// Set up the handlers (app.yaml restricts /task/ to admins and cron)
+
+func init() {
+	http.Handle("/task/purge", Wrapper(Purge))
+	http.Handle("/task/series", Wrapper(BackfillSeries))
+	http.Handle("/task/progress", Wrapper(BackfillProgress))
+}
+
+// Purge permanently deletes the stories which have been in the trash for
+// longer than TrashRetention.
+func Purge(c appengine.Context, w http.ResponseWriter, r *http.Request) error {
+	q := datastore.NewQuery("Story")
+	q.Filter("Deleted >", time.Time{})
+	q.Filter("Deleted <", time.Now().Add(-TrashRetention))
+	q.KeysOnly()
+
+	keys, err := q.GetAll(c, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		s := &Story{key: key, ID: key.StringID()}
+		if err := s.Delete(c); err != nil {
+			return err
+		}
+		c.Infof("Purged story %s", s.ID)
+	}
+	return nil
+}
+
+// BackfillSeries gives each series saved before series kept a copy of their
+// stories' titles, slugs, and trash state the copies.  It only needs to be
+// run once; series which have them are left alone.
+func BackfillSeries(c appengine.Context, w http.ResponseWriter, r *http.Request) error {
+	var all []*Series
+	keys, err := datastore.NewQuery("Series").GetAll(c, &all)
+	if err != nil {
+		return err
+	}
+
+	for i, key := range keys {
+		if all[i].complete() {
+			continue
+		}
+		se := &Series{key: key, ID: key.StringID()}
+		if err := se.update(c, func(*Series) error { return nil }); err != nil {
+			return err
+		}
+		c.Infof("Series %s: copied %d stories", se.ID, len(se.Stories))
+	}
+	return nil
+}
+
+// BackfillProgress sums up the progress of each user who recorded progress
+// before it was summed up as it was recorded.  It only needs to be run once,
+// but running it again does no harm.
+func BackfillProgress(c appengine.Context, w http.ResponseWriter, r *http.Request) error {
+	keys, err := datastore.NewQuery("Progress").KeysOnly().GetAll(c, nil)
+	if err != nil {
+		return err
+	}
+
+	done := map[string]bool{}
+	for _, key := range keys {
+		user := key.Parent()
+		if done[user.StringID()] {
+			continue
+		}
+		done[user.StringID()] = true
+
+		t := new(ProgressTotal)
+		err := datastore.RunInTransaction(c, func(tx appengine.Context) error {
+			var progress []Progress
+			if _, err := datastore.NewQuery("Progress").Ancestor(user).GetAll(tx, &progress); err != nil {
+				return err
+			}
+			daily := map[string]int{}
+			var days []string
+			for _, p := range progress {
+				if _, ok := daily[p.Day]; !ok {
+					days = append(days, p.Day)
+				}
+				daily[p.Day] += p.Words
+			}
+			sort.Strings(days)
+
+			*t = ProgressTotal{}
+			for _, day := range days {
+				t.add(day, daily[day])
+			}
+			_, err := datastore.Put(tx, progressTotalKey(tx, user), t)
+			return err
+		}, nil)
+		if err != nil {
+			return err
+		}
+		c.Infof("User %s: %d words by %s", user.StringID(), t.Words, t.Day)
+	}
+	return nil
+}